@@ -0,0 +1,238 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	urlpath "path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultCoverFilename = "folder.jpg"
+
+// maxImageSize keeps a misconfigured image URL from filling up the disk or
+// every tagged episode with megabytes of artwork.
+const maxImageSize = 5 << 20
+
+var ErrCouldNotDownloadImage = errors.New("Could not download image")
+
+// imageClient fetches the artwork, which happens on every sync, so a slow
+// image host can't hold it up indefinitely.
+var imageClient = &http.Client{Timeout: 30 * time.Second}
+
+// Artwork configures the images pcd keeps next to the episodes.
+type Artwork struct {
+	// Cover is the file name of the podcast cover in Path, folder.jpg by
+	// default. Set it to "none" to not download the cover.
+	Cover string
+	// Episodes downloads the artwork of every downloaded episode that has
+	// its own, next to the audio file.
+	Episodes bool
+}
+
+// CachedImage is the record of an image fetched by pcd. The validators the
+// server sent are used to only fetch it again when it changed.
+type CachedImage struct {
+	URL          string
+	Filename     string
+	ETag         string
+	LastModified string
+}
+
+func (a *Artwork) coverFilename() string {
+	switch a.Cover {
+	case "":
+		return defaultCoverFilename
+	case "none":
+		return ""
+	default:
+		return a.Cover
+	}
+}
+
+// SyncArtwork fetches the podcast cover and, when enabled, the artwork of the
+// downloaded episodes. Images that didn't change since the last time aren't
+// downloaded again.
+func (p *Podcast) SyncArtwork(coverURL string) error {
+	state, err := LoadState(p.Path)
+	if err != nil {
+		return err
+	}
+	state.CoverURL = coverURL
+
+	var lastErr error
+	if filename := p.Artwork.coverFilename(); filename != "" && coverURL != "" {
+		if err := p.fetchImage(state, coverURL, filename); err != nil {
+			lastErr = err
+		}
+	}
+
+	if p.Artwork.Episodes {
+		for i := range p.Episodes {
+			if err := p.fetchEpisodeArtwork(state, &p.Episodes[i]); err != nil {
+				lastErr = err
+			}
+		}
+	}
+
+	if err := state.Save(p.Path); err != nil {
+		return err
+	}
+
+	return lastErr
+}
+
+// FetchEpisodeArtwork downloads the artwork of a downloaded episode next to
+// its audio file. Episodes that only have the podcast cover are skipped.
+func (p *Podcast) FetchEpisodeArtwork(e *Episode) error {
+	state, err := LoadState(p.Path)
+	if err != nil {
+		return err
+	}
+
+	if err := p.fetchEpisodeArtwork(state, e); err != nil {
+		return err
+	}
+
+	return state.Save(p.Path)
+}
+
+func (p *Podcast) fetchEpisodeArtwork(state *State, e *Episode) error {
+	download := state.Find(e)
+	if download == nil || download.Pruned || e.Image == "" || e.Image == state.CoverURL {
+		return nil
+	}
+
+	filename := strings.TrimSuffix(download.Filename, filepath.Ext(download.Filename)) + imageExtension(e.Image)
+	if err := p.fetchImage(state, e.Image, filename); err != nil {
+		return err
+	}
+	download.Image = filename
+
+	return nil
+}
+
+// imageExtension guesses the extension of the image at rawurl, so players
+// looking for <episode>.jpg or <episode>.png find it.
+func imageExtension(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ".jpg"
+	}
+
+	switch ext := strings.ToLower(urlpath.Ext(u.Path)); ext {
+	case ".png", ".webp", ".gif":
+		return ext
+	default:
+		return ".jpg"
+	}
+}
+
+// fetchImage downloads the image at rawurl into filename in the podcast's
+// path, with a conditional request when the image was fetched before.
+func (p *Podcast) fetchImage(state *State, rawurl, filename string) error {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		log.Printf("Invalid image url: %#v", err)
+		return ErrCouldNotDownloadImage
+	}
+
+	fpath := filepath.Join(p.Path, filename)
+	cached := state.findImage(filename)
+	if cached != nil && cached.URL == rawurl {
+		if _, err := os.Stat(fpath); err == nil {
+			if cached.ETag != "" {
+				req.Header.Set("If-None-Match", cached.ETag)
+			}
+			if cached.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cached.LastModified)
+			}
+		}
+	}
+
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		log.Printf("Could not download image: %#v", err)
+		return ErrCouldNotDownloadImage
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil
+	default:
+		log.Printf("Could not download image %s: %s", rawurl, resp.Status)
+		return ErrCouldNotDownloadImage
+	}
+
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		log.Printf("Could not download image: %#v", err)
+		return ErrCouldNotDownloadImage
+	}
+	if len(image) > maxImageSize {
+		log.Printf("Image %s is too large", rawurl)
+		return ErrCouldNotDownloadImage
+	}
+
+	// write next to the old image and swap, so an interrupted sync
+	// doesn't leave half an image behind
+	tmp := fpath + ".part"
+	if err := os.WriteFile(tmp, image, 0644); err != nil {
+		log.Printf("Could not write image: %#v", err)
+		return ErrFilesystemError
+	}
+	if err := os.Rename(tmp, fpath); err != nil {
+		os.Remove(tmp)
+		log.Printf("Could not write image: %#v", err)
+		return ErrFilesystemError
+	}
+
+	state.setImage(CachedImage{
+		URL:          rawurl,
+		Filename:     filename,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	})
+
+	return nil
+}
+
+func (s *State) findImage(filename string) *CachedImage {
+	for i := range s.Images {
+		if s.Images[i].Filename == filename {
+			return &s.Images[i]
+		}
+	}
+	return nil
+}
+
+func (s *State) setImage(image CachedImage) {
+	if cached := s.findImage(image.Filename); cached != nil {
+		*cached = image
+		return
+	}
+	s.Images = append(s.Images, image)
+}
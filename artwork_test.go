@@ -0,0 +1,81 @@
+package pcd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncArtwork(t *testing.T) {
+	var fetched []string
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feed":
+			feed := strings.Replace(Podcastfeed, podcastIcon, ts.URL+"/cover.jpg", 1)
+			feed = strings.Replace(feed, "http://example.com/podcast-1/podcast.mp3", ts.URL+"/episode.mp3", 1)
+			feed = strings.Replace(feed, "<guid>", `<itunes:image href="`+ts.URL+`/episode.png"/><guid>`, 1)
+			w.Write([]byte(feed))
+		case "/episode.mp3":
+			w.Write([]byte("audio"))
+		default:
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			fetched = append(fetched, r.URL.Path)
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte("image " + r.URL.Path))
+		}
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{
+		Name:    "test",
+		Feed:    ts.URL + "/feed",
+		Path:    randomPath(t),
+		Artwork: Artwork{Episodes: true},
+	}
+	defer os.RemoveAll(podcast.Path)
+
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(podcast.Path, "folder.jpg"))
+	if string(content) != "image /cover.jpg" {
+		t.Errorf("Expected the cover to be downloaded, but got %q", content)
+	}
+
+	// episode artwork is only fetched for downloaded episodes
+	episode := &podcast.Episodes[0]
+	if err := episode.Download(podcast.Path, ioutil.Discard, ""); err != nil {
+		t.Fatalf("Expected to be able to download, but got: %#v", err)
+	}
+	if err := podcast.FetchEpisodeArtwork(episode); err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+	content, _ = os.ReadFile(filepath.Join(podcast.Path, "episode.png"))
+	if string(content) != "image /episode.png" {
+		t.Errorf("Expected the episode artwork next to the episode, but got %q", content)
+	}
+
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if len(fetched) != 2 {
+		t.Errorf("Expected unchanged images not to be fetched again, but got %v", fetched)
+	}
+
+	// pruning removes the artwork along with the episode
+	podcast.Retention = Retention{MaxSize: "1"}
+	if _, err := podcast.Prune(false); err != nil {
+		t.Fatalf("Expected to be able to prune, but got: %#v", err)
+	}
+	if _, err := os.Stat(filepath.Join(podcast.Path, "episode.png")); !os.IsNotExist(err) {
+		t.Errorf("Expected the episode artwork to be removed, but got: %#v", err)
+	}
+}
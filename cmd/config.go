@@ -0,0 +1,294 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kvannotten/pcd"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// editConfig rewrites the configuration file with edit, under the config
+// lock. Only YAML configurations can be edited.
+func editConfig(action string, edit func(config []byte) ([]byte, error)) error {
+	path := viper.ConfigFileUsed()
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".yml" && ext != ".yaml" {
+		return fmt.Errorf("can only edit YAML configuration files, not %s", path)
+	}
+
+	l, err := lockConfig(action)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	config, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	config, err = edit(config)
+	if err != nil {
+		return err
+	}
+	// never leave a configuration behind that pcd can't read anymore
+	var check yaml.Node
+	if err := yaml.Unmarshal(config, &check); err != nil {
+		return fmt.Errorf("the edited configuration is invalid, leaving it as it was: %v", err)
+	}
+
+	// a crash halfway must not leave a truncated configuration behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, config, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// setPodcastFeed returns the configuration with the feed of the podcast set
+// to feed. Only the url itself is replaced, so the formatting and comments
+// of the file are kept.
+func setPodcastFeed(config []byte, name, feed string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(config, &doc); err != nil {
+		return nil, err
+	}
+
+	node := podcastFeedNode(&doc, name)
+	if node == nil {
+		return nil, fmt.Errorf("could not find the feed of %s in the configuration", name)
+	}
+
+	lines := bytes.SplitAfter(config, []byte("\n"))
+	if node.Line > len(lines) {
+		return nil, fmt.Errorf("could not find the feed of %s in the configuration", name)
+	}
+	line := lines[node.Line-1]
+	start := node.Column - 1
+	if start >= len(line) {
+		return nil, fmt.Errorf("could not find the feed of %s in the configuration", name)
+	}
+	end := start + len(node.Value)
+	replacement := feed
+	switch node.Style {
+	case yaml.DoubleQuotedStyle, yaml.SingleQuotedStyle:
+		quote := line[start]
+		end = start + 1 + bytes.IndexByte(line[start+1:], quote) + 1
+		replacement = string(quote) + feed + string(quote)
+	case 0:
+	default:
+		return nil, fmt.Errorf("could not update the feed of %s, it isn't a plain or quoted string", name)
+	}
+	if end > len(line) || end <= start || (node.Style == 0 && string(line[start:end]) != node.Value) {
+		return nil, fmt.Errorf("could not find the feed of %s in the configuration", name)
+	}
+
+	lines[node.Line-1] = append(append(append([]byte{}, line[:start]...), replacement...), line[end:]...)
+	return bytes.Join(lines, nil), nil
+}
+
+// podcastFeedNode returns the node of the feed url of the podcast with the
+// name in the configuration document.
+func podcastFeedNode(doc *yaml.Node, name string) *yaml.Node {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	podcasts := mappingValue(doc.Content[0], "podcasts")
+	if podcasts == nil || podcasts.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	for _, podcast := range podcasts.Content {
+		if n := mappingValue(podcast, "name"); n != nil && n.Value == name {
+			if feed := mappingValue(podcast, "feed"); feed != nil && feed.Kind == yaml.ScalarNode {
+				return feed
+			}
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value of the key in the mapping node.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// addPodcast returns the configuration with a podcast for the feed added to
+// the end of the podcasts list, with the next free id and a name made from
+// the title. Its path is the directory with that name in dir. The rest of
+// the file is left as it is.
+func addPodcast(config []byte, title, feed, dir string) ([]byte, *pcd.Podcast, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(config, &doc); err != nil {
+		return nil, nil, err
+	}
+	var root *yaml.Node
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if root == nil || root.Kind != yaml.MappingNode || root.Style&yaml.FlowStyle != 0 {
+		return nil, nil, fmt.Errorf("could not add a podcast, the configuration isn't a YAML mapping")
+	}
+
+	var key, podcasts, next *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "podcasts" {
+			key, podcasts = root.Content[i], root.Content[i+1]
+			if i+2 < len(root.Content) {
+				next = root.Content[i+2]
+			}
+		}
+	}
+
+	id, names := 0, make(map[string]bool)
+	indent := "  "
+	if podcasts != nil && podcasts.Kind == yaml.SequenceNode {
+		if podcasts.Style&yaml.FlowStyle != 0 {
+			return nil, nil, fmt.Errorf("could not add a podcast, the podcasts list is in flow style")
+		}
+		for _, p := range podcasts.Content {
+			if n := mappingValue(p, "id"); n != nil {
+				if i, err := strconv.Atoi(n.Value); err == nil && i > id {
+					id = i
+				}
+			}
+			if n := mappingValue(p, "name"); n != nil {
+				names[n.Value] = true
+			}
+			if n := mappingValue(p, "feed"); n != nil && n.Value == feed {
+				return nil, nil, fmt.Errorf("already subscribed to %s", feed)
+			}
+		}
+		// line up with the podcasts that are there, "- " comes before the
+		// first key of an entry
+		if len(podcasts.Content) > 0 && podcasts.Content[0].Column > 2 {
+			indent = strings.Repeat(" ", podcasts.Content[0].Column-3)
+		}
+	} else if podcasts != nil && (podcasts.Tag != "!!null" || podcasts.Style&yaml.TaggedStyle != 0) {
+		return nil, nil, fmt.Errorf("could not add a podcast, podcasts isn't a list")
+	}
+
+	name := podcastName(title)
+	for i := 2; names[name]; i++ {
+		name = fmt.Sprintf("%s_%d", podcastName(title), i)
+	}
+	podcast := &pcd.Podcast{
+		ID:   id + 1,
+		Name: name,
+		Path: filepath.Join(dir, name),
+		Feed: feed,
+	}
+
+	var entry bytes.Buffer
+	if podcasts == nil {
+		entry.WriteString("podcasts:\n")
+	}
+	fmt.Fprintf(&entry, "%s- id: %d\n", indent, podcast.ID)
+	for _, field := range [][2]string{{"name", podcast.Name}, {"path", podcast.Path}, {"feed", podcast.Feed}} {
+		value, err := yaml.Marshal(field[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Fprintf(&entry, "%s  %s: %s", indent, field[0], value)
+	}
+
+	lines := bytes.SplitAfter(config, []byte("\n"))
+	if n := len(lines); n > 0 && len(lines[n-1]) == 0 {
+		lines = lines[:n-1]
+	}
+	if n := len(lines); n > 0 && !bytes.HasSuffix(lines[n-1], []byte("\n")) {
+		lines[n-1] = append(lines[n-1], '\n')
+	}
+
+	// an explicit null, podcasts: ~, makes way for the list
+	if podcasts != nil && podcasts.Kind == yaml.ScalarNode && podcasts.Value != "" {
+		line := lines[podcasts.Line-1]
+		start := podcasts.Column - 1
+		end := start + len(podcasts.Value)
+		if podcasts.Line != key.Line || end > len(line) || string(line[start:end]) != podcasts.Value {
+			return nil, nil, fmt.Errorf("could not add a podcast, podcasts isn't a list")
+		}
+		lines[podcasts.Line-1] = append(append([]byte{}, bytes.TrimRight(line[:start], " \t")...), line[end:]...)
+	}
+
+	// the entry goes right after the podcasts list, which ends where the
+	// next key starts, minus the blank lines and comments that lead up to it
+	at := len(lines)
+	switch {
+	case podcasts == nil:
+	case next != nil:
+		at = next.Line - 1
+		for at > key.Line && isBlankOrComment(lines[at-1]) {
+			at--
+		}
+	default:
+		for at > key.Line && len(bytes.TrimSpace(lines[at-1])) == 0 {
+			at--
+		}
+	}
+
+	result := append([][]byte{}, lines[:at]...)
+	result = append(result, entry.Bytes())
+	result = append(result, lines[at:]...)
+	return bytes.Join(result, nil), podcast, nil
+}
+
+// isBlankOrComment tells whether the line is blank or a comment that isn't
+// indented, so belongs to the next key rather than the podcasts list.
+func isBlankOrComment(line []byte) bool {
+	return len(bytes.TrimSpace(line)) == 0 || line[0] == '#'
+}
+
+// podcastName turns a title into a podcast name like the ones in the
+// example configuration: "The Biggest Problem" becomes the_biggest_problem.
+func podcastName(title string) string {
+	var sb strings.Builder
+	underscore := false
+	for _, c := range strings.ToLower(title) {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if underscore && sb.Len() > 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(c)
+			underscore = false
+		} else {
+			underscore = true
+		}
+	}
+	if sb.Len() == 0 {
+		return "podcast"
+	}
+	return sb.String()
+}
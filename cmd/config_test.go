@@ -0,0 +1,167 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const testConfig = `---
+podcasts:
+  - id: 1
+    name: biggest_problem
+    path: /some/path/to/biggest_problem
+    feed: http://feeds.feedburner.com/TheBiggestProblemInTheUniverse # moved?
+  - id: 2
+    name: some_other
+    feed: "http://feeds.example.com/SomeOther.rss"
+  - {id: 3, name: flow, feed: 'http://example.com/flow.rss'}
+`
+
+func TestSetPodcastFeed(t *testing.T) {
+	table := []struct {
+		name string
+		feed string
+		old  string
+		new  string
+	}{
+		{"biggest_problem", "https://example.com/biggest.rss", "feed: http://feeds.feedburner.com/TheBiggestProblemInTheUniverse # moved?", "feed: https://example.com/biggest.rss # moved?"},
+		{"some_other", "https://example.com/other.rss", `feed: "http://feeds.example.com/SomeOther.rss"`, `feed: "https://example.com/other.rss"`},
+		{"flow", "https://example.com/flow.rss", `feed: 'http://example.com/flow.rss'}`, `feed: 'https://example.com/flow.rss'}`},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			got, err := setPodcastFeed([]byte(testConfig), e.name, e.feed)
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %#v", err)
+			}
+			want := strings.Replace(testConfig, e.old, e.new, 1)
+			if string(got) != want {
+				t.Errorf("Expected %#v, but got: %#v", want, string(got))
+			}
+		})
+	}
+
+	if _, err := setPodcastFeed([]byte(testConfig), "unknown", "https://example.com/"); err == nil {
+		t.Errorf("Expected an error for an unknown podcast")
+	}
+}
+
+func TestAddPodcast(t *testing.T) {
+	table := []struct {
+		name   string
+		config string
+		title  string
+		want   string
+	}{
+		{
+			"appended",
+			testConfig,
+			"The Biggest Problem!",
+			testConfig + `  - id: 4
+    name: the_biggest_problem
+    path: /podcasts/the_biggest_problem
+    feed: http://example.com/new.rss
+`,
+		},
+		{
+			"before the next key",
+			"podcasts:\n    - id: 7\n      name: biggest_problem\n\n# the daemon\ndaemon:\n  addr: localhost:7331\n",
+			"Biggest Problem",
+			"podcasts:\n    - id: 7\n      name: biggest_problem\n    - id: 8\n      name: biggest_problem_2\n      path: /podcasts/biggest_problem_2\n      feed: http://example.com/new.rss\n\n# the daemon\ndaemon:\n  addr: localhost:7331\n",
+		},
+		{
+			"empty list",
+			"podcasts:\nserve:\n  addr: \":8080\"",
+			"Ünïcode: Podcast",
+			"podcasts:\n  - id: 1\n    name: ünïcode_podcast\n    path: /podcasts/ünïcode_podcast\n    feed: http://example.com/new.rss\nserve:\n  addr: \":8080\"\n",
+		},
+		{
+			"explicit null",
+			"podcasts: ~\nserve:\n  addr: \":8080\"\n",
+			"Null",
+			"podcasts:\n  - id: 1\n    name: \"null\"\n    path: /podcasts/null\n    feed: http://example.com/new.rss\nserve:\n  addr: \":8080\"\n",
+		},
+		{
+			"null with a comment",
+			"podcasts: null # none yet\n",
+			"Podcast",
+			"podcasts: # none yet\n  - id: 1\n    name: podcast\n    path: /podcasts/podcast\n    feed: http://example.com/new.rss\n",
+		},
+		{
+			"no list",
+			"serve:\n  addr: \":8080\"\n",
+			"",
+			"serve:\n  addr: \":8080\"\npodcasts:\n  - id: 1\n    name: podcast\n    path: /podcasts/podcast\n    feed: http://example.com/new.rss\n",
+		},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			got, _, err := addPodcast([]byte(e.config), e.title, "http://example.com/new.rss", "/podcasts")
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %#v", err)
+			}
+			if string(got) != e.want {
+				t.Errorf("Expected %#v, but got: %#v", e.want, string(got))
+			}
+			var doc yaml.Node
+			if err := yaml.Unmarshal(got, &doc); err != nil {
+				t.Errorf("Expected valid YAML, but got: %v", err)
+			}
+		})
+	}
+
+	if _, _, err := addPodcast([]byte(testConfig), "Again", "http://example.com/flow.rss", "/podcasts"); err == nil {
+		t.Errorf("Expected an error for a feed that is already subscribed to")
+	}
+	if _, _, err := addPodcast([]byte("podcasts: []\n"), "Flow", "http://example.com/new.rss", "/podcasts"); err == nil {
+		t.Errorf("Expected an error for a podcasts list in flow style")
+	}
+	if _, _, err := addPodcast([]byte("podcasts: !!null\n"), "Tagged", "http://example.com/new.rss", "/podcasts"); err == nil {
+		t.Errorf("Expected an error for a tagged null")
+	}
+}
+
+func TestEditConfigKeepsInvalidEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pcd.yml")
+	if err := os.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	viper.SetConfigFile(path)
+	defer viper.SetConfigFile("")
+
+	err := editConfig("testing", func(config []byte) ([]byte, error) {
+		return append(config, "podcasts: ~\n  - id: 4\n"...), nil
+	})
+	if err == nil {
+		t.Errorf("Expected an error for an edit that breaks the configuration")
+	}
+	config, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config) != testConfig {
+		t.Errorf("Expected %#v, but got: %#v", testConfig, string(config))
+	}
+}
@@ -0,0 +1,601 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kvannotten/pcd"
+	"github.com/kvannotten/pcd/lock"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Runs a local HTTP JSON API to control pcd",
+	Long: `
+This command runs pcd in the foreground as a small HTTP server with a JSON API,
+for dashboards and home automation that want to trigger syncs and downloads.
+It only listens on localhost or on a unix socket, and every request must carry
+the token as 'Authorization: Bearer <token>'.
+
+  GET  /podcasts                                  the configured podcasts
+  GET  /podcasts/<id>/episodes                    the episodes of a podcast
+  POST /sync                                      sync all podcasts
+  POST /podcasts/<id>/sync                        sync a podcast
+  POST /podcasts/<id>/episodes/<episode>/download download an episode,
+                                                  ?force=true to download it again
+  GET  /jobs                                      the queued and recent jobs
+  GET  /jobs/<id>                                 a single job
+  GET  /events                                    job progress as server-sent events
+
+Syncs and downloads are queued and run one at a time. The token is read from
+--token or the config, and generated and printed when neither is set:
+
+daemon:
+  addr: localhost:7331
+  token: a-long-random-string`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// jobs queue up behind pcd processes started from the command line
+		waitForLock = true
+
+		addr, _ := cmd.Flags().GetString("addr")
+		if !cmd.Flags().Changed("addr") && viper.IsSet("daemon.addr") {
+			addr = viper.GetString("daemon.addr")
+		}
+		socket, _ := cmd.Flags().GetString("socket")
+		if socket == "" {
+			socket = viper.GetString("daemon.socket")
+		}
+		token, _ := cmd.Flags().GetString("token")
+		if token == "" {
+			token = viper.GetString("daemon.token")
+		}
+		if token == "" {
+			token = generateToken()
+			fmt.Fprintln(os.Stderr, "Generated API token:", token)
+		}
+
+		listener, err := daemonListener(addr, socket)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		d := newDaemon(token, findAll)
+		go d.work()
+
+		srv := &http.Server{Handler: d}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdown)
+		}()
+
+		log.Printf("Listening on %s", listener.Addr())
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Could not serve: %v", err)
+		}
+	},
+}
+
+// daemonListener listens on the unix socket, if given, or on addr, which
+// must be a loopback address.
+func daemonListener(addr, socket string) (net.Listener, error) {
+	if socket != "" {
+		// a socket left behind by a previous run would make Listen fail
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(socket)
+		}
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("could not listen on %s: %v", socket, err)
+		}
+		if err := os.Chmod(socket, 0600); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("could not restrict access to %s: %v", socket, err)
+		}
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("refusing to listen on %s, the daemon only listens on localhost or a unix socket", addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %v", addr, err)
+	}
+	return listener, nil
+}
+
+func generateToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Could not generate a token: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Job types and states
+const (
+	jobSync     = "sync"
+	jobDownload = "download"
+
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// finished jobs kept for GET /jobs
+const keepJobs = 100
+
+type job struct {
+	ID        int             `json:"id"`
+	Type      string          `json:"type"`
+	PodcastID int             `json:"podcast_id"`
+	Podcast   string          `json:"podcast"`
+	EpisodeID int             `json:"episode_id,omitempty"`
+	Episode   string          `json:"episode,omitempty"`
+	State     string          `json:"state"`
+	Bytes     int64           `json:"bytes,omitempty"`
+	Total     int64           `json:"total,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Result    *pcd.SyncResult `json:"result,omitempty"`
+	Created   time.Time       `json:"created"`
+}
+
+// queuedJob is a job with the podcast and episode it works on. They are
+// kept out of the job, so the worker can change them while the job is copied
+// for the API.
+type queuedJob struct {
+	job     *job
+	podcast pcd.Podcast
+	episode pcd.Episode
+	// force replaces an earlier download of the episode
+	force bool
+}
+
+// podcastInfo is what the API tells about a podcast, leaving out its
+// credentials.
+type podcastInfo struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Feed     string `json:"feed"`
+	Path     string `json:"path"`
+	Episodes int    `json:"episodes"`
+}
+
+type daemon struct {
+	token    string
+	podcasts func() []pcd.Podcast
+
+	queue chan queuedJob
+
+	mu          sync.Mutex
+	jobs        []*job
+	nextID      int
+	subscribers map[chan []byte]bool
+}
+
+func newDaemon(token string, podcasts func() []pcd.Podcast) *daemon {
+	return &daemon{
+		token:       token,
+		podcasts:    podcasts,
+		queue:       make(chan queuedJob, 1000),
+		subscribers: make(map[chan []byte]bool),
+	}
+}
+
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !d.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	route := func(method string, pattern ...string) bool {
+		if r.Method != method || len(parts) != len(pattern) {
+			return false
+		}
+		for i := range pattern {
+			if pattern[i] != "*" && pattern[i] != parts[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case route("GET", "podcasts"):
+		d.listPodcasts(w)
+	case route("GET", "podcasts", "*", "episodes"):
+		d.listEpisodes(w, parts[1])
+	case route("POST", "sync"):
+		d.enqueueSync(w, "")
+	case route("POST", "podcasts", "*", "sync"):
+		d.enqueueSync(w, parts[1])
+	case route("POST", "podcasts", "*", "episodes", "*", "download"):
+		d.enqueueDownload(w, parts[1], parts[3], r.URL.Query().Get("force") == "true")
+	case route("GET", "jobs"):
+		d.mu.Lock()
+		jobs := d.jobs
+		d.mu.Unlock()
+		writeJSON(w, http.StatusOK, d.snapshots(jobs))
+	case route("GET", "jobs", "*"):
+		d.getJob(w, parts[1])
+	case route("GET", "events"):
+		d.events(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorized checks the bearer token. EventSource can't set headers, so
+// the token may also be passed as the access_token parameter.
+func (d *daemon) authorized(r *http.Request) bool {
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) == 1
+}
+
+func (d *daemon) findPodcast(id string) *pcd.Podcast {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil
+	}
+	podcasts := d.podcasts()
+	for i := range podcasts {
+		if podcasts[i].ID == n {
+			return &podcasts[i]
+		}
+	}
+	return nil
+}
+
+func (d *daemon) listPodcasts(w http.ResponseWriter) {
+	infos := []podcastInfo{}
+	for _, podcast := range d.podcasts() {
+		info := podcastInfo{ID: podcast.ID, Name: podcast.Name, Feed: podcast.Feed, Path: podcast.Path}
+		// podcasts that were never synced have no episodes yet
+		if err := podcast.Load(); err == nil {
+			info.Episodes = len(podcast.Episodes)
+		}
+		infos = append(infos, info)
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (d *daemon) listEpisodes(w http.ResponseWriter, id string) {
+	podcast := d.findPodcast(id)
+	if podcast == nil {
+		writeError(w, http.StatusNotFound, "podcast not found")
+		return
+	}
+	if err := podcast.Load(); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if podcast.Episodes == nil {
+		podcast.Episodes = []pcd.Episode{}
+	}
+	writeJSON(w, http.StatusOK, podcast.Episodes)
+}
+
+// enqueueSync queues a sync of the podcast with the id, or of all podcasts.
+func (d *daemon) enqueueSync(w http.ResponseWriter, id string) {
+	var podcasts []pcd.Podcast
+	if id == "" {
+		podcasts = d.podcasts()
+	} else {
+		podcast := d.findPodcast(id)
+		if podcast == nil {
+			writeError(w, http.StatusNotFound, "podcast not found")
+			return
+		}
+		podcasts = append(podcasts, *podcast)
+	}
+
+	var jobs []*job
+	for _, podcast := range podcasts {
+		j := d.enqueue(queuedJob{job: &job{Type: jobSync}, podcast: podcast})
+		if j == nil {
+			writeError(w, http.StatusServiceUnavailable, "too many queued jobs")
+			return
+		}
+		jobs = append(jobs, j)
+	}
+	writeJSON(w, http.StatusAccepted, d.snapshots(jobs))
+}
+
+func (d *daemon) enqueueDownload(w http.ResponseWriter, id, episodeID string, force bool) {
+	podcast := d.findPodcast(id)
+	if podcast == nil {
+		writeError(w, http.StatusNotFound, "podcast not found")
+		return
+	}
+	if err := podcast.Load(); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	n, _ := strconv.Atoi(episodeID)
+	var episode *pcd.Episode
+	for i := range podcast.Episodes {
+		if podcast.Episodes[i].ID == n {
+			episode = &podcast.Episodes[i]
+		}
+	}
+	if episode == nil {
+		writeError(w, http.StatusNotFound, "episode not found")
+		return
+	}
+	if episode.Downloaded && !force {
+		writeError(w, http.StatusConflict, "episode was already downloaded, use force=true to download it again")
+		return
+	}
+
+	j := d.enqueue(queuedJob{job: &job{Type: jobDownload}, podcast: *podcast, episode: *episode, force: force})
+	if j == nil {
+		writeError(w, http.StatusServiceUnavailable, "too many queued jobs")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, d.snapshots([]*job{j}))
+}
+
+func (d *daemon) getJob(w http.ResponseWriter, id string) {
+	n, _ := strconv.Atoi(id)
+
+	d.mu.Lock()
+	jobs := d.jobs
+	d.mu.Unlock()
+	for _, j := range d.snapshots(jobs) {
+		if j.ID == n {
+			writeJSON(w, http.StatusOK, j)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "job not found")
+}
+
+// enqueue registers the job and queues it, it returns nil when the queue is
+// full.
+func (d *daemon) enqueue(q queuedJob) *job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	j := q.job
+	j.ID = d.nextID + 1
+	j.PodcastID = q.podcast.ID
+	j.Podcast = q.podcast.Name
+	j.EpisodeID = q.episode.ID
+	j.Episode = q.episode.Title
+	j.State = jobQueued
+	j.Created = time.Now()
+
+	// the worker takes the lock before touching the job, so it can't
+	// start on it before it is registered
+	select {
+	case d.queue <- q:
+	default:
+		return nil
+	}
+
+	d.nextID++
+	d.jobs = append(d.jobs, j)
+	d.publish(j)
+
+	return j
+}
+
+// work runs the queued jobs one at a time, so two of them never write to the
+// same podcast directory at once.
+func (d *daemon) work() {
+	for w := range d.queue {
+		j := w.job
+		d.update(j, func(j *job) { j.State = jobRunning })
+
+		var err error
+		switch j.Type {
+		case jobSync:
+			var result *pcd.SyncResult
+			result, err = syncPodcast(&w.podcast)
+			d.update(j, func(j *job) { j.Result = result })
+		case jobDownload:
+			d.update(j, func(j *job) { j.Total = w.episode.Length })
+			var l *lock.Lock
+			if l, err = lockPodcast(&w.podcast, "downloading into"); err == nil {
+				err = fetchEpisode(&w.podcast, &w.episode, &jobProgress{daemon: d, job: j}, w.force)
+				l.Release()
+			}
+		}
+
+		d.update(j, func(j *job) {
+			if err != nil {
+				j.State = jobFailed
+				j.Error = err.Error()
+			} else {
+				j.State = jobDone
+			}
+		})
+		d.forgetFinished()
+	}
+}
+
+// update changes the job under the lock and tells the subscribers.
+func (d *daemon) update(j *job, fn func(*job)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(j)
+	d.publish(j)
+}
+
+func (d *daemon) forgetFinished() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	finished := 0
+	for _, j := range d.jobs {
+		if j.State == jobDone || j.State == jobFailed {
+			finished++
+		}
+	}
+
+	var jobs []*job
+	for _, j := range d.jobs {
+		if finished > keepJobs && (j.State == jobDone || j.State == jobFailed) {
+			finished--
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	d.jobs = jobs
+}
+
+// snapshots copies the jobs under the lock, for encoding outside of it.
+func (d *daemon) snapshots(jobs []*job) []job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	copies := make([]job, 0, len(jobs))
+	for _, j := range jobs {
+		copies = append(copies, *j)
+	}
+	return copies
+}
+
+// publish sends the job to the event subscribers. It must be called with the
+// lock held. Subscribers that can't keep up miss events rather than holding
+// up the jobs.
+func (d *daemon) publish(j *job) {
+	data, err := json.Marshal(j)
+	if err != nil {
+		log.Printf("Could not encode job: %v", err)
+		return
+	}
+	for ch := range d.subscribers {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+// events streams the changes to the jobs as server-sent events.
+func (d *daemon) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	ch := make(chan []byte, 64)
+	d.mu.Lock()
+	d.subscribers[ch] = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.subscribers, ch)
+		d.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case data := <-ch:
+			fmt.Fprintf(w, "event: job\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// jobProgress records the bytes written by a download on its job, telling
+// the subscribers at most a few times a second.
+type jobProgress struct {
+	daemon *daemon
+	job    *job
+	last   time.Time
+}
+
+func (p *jobProgress) Write(b []byte) (int, error) {
+	p.daemon.mu.Lock()
+	defer p.daemon.mu.Unlock()
+
+	p.job.Bytes += int64(len(b))
+	if time.Since(p.last) >= 250*time.Millisecond {
+		p.last = time.Now()
+		p.daemon.publish(p.job)
+	}
+	return len(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Could not encode response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().String("addr", "localhost:7331", "Loopback address to listen on")
+	daemonCmd.Flags().String("socket", "", "Unix socket to listen on instead of addr")
+	daemonCmd.Flags().String("token", "", "Token clients must send as 'Authorization: Bearer <token>'")
+}
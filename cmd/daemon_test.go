@@ -0,0 +1,208 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kvannotten/pcd"
+)
+
+func TestDaemon(t *testing.T) {
+	var origin *httptest.Server
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed" {
+			io.WriteString(w, strings.Replace(serveFeed, "{{server}}", origin.URL, 1))
+			return
+		}
+		io.WriteString(w, "0123456789")
+	}))
+	defer origin.Close()
+
+	podcast := pcd.Podcast{
+		ID:       1,
+		Name:     "my podcast",
+		Feed:     origin.URL + "/feed",
+		Path:     t.TempDir(),
+		Password: "secret",
+		Artwork:  pcd.Artwork{Cover: "none"},
+	}
+	d := newDaemon("token", func() []pcd.Podcast { return []pcd.Podcast{podcast} })
+	go d.work()
+	ts := httptest.NewServer(d)
+	defer ts.Close()
+
+	request := func(method, path string) (int, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	resp, err := http.Get(ts.URL + "/podcasts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected requests without a token to be refused, but got: %d", resp.StatusCode)
+	}
+
+	status, body := request("GET", "/podcasts")
+	if status != http.StatusOK || !strings.Contains(body, `"name":"my podcast"`) || strings.Contains(body, "secret") {
+		t.Errorf("Expected the podcasts without credentials, but got: %d %s", status, body)
+	}
+
+	// follow the jobs as they run
+	events, err := http.Get(ts.URL + "/events?access_token=token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	scanner := bufio.NewScanner(events.Body)
+	waitFor := func(typ string) job {
+		deadline := time.AfterFunc(5*time.Second, func() { events.Body.Close() })
+		defer deadline.Stop()
+		for scanner.Scan() {
+			data := strings.TrimPrefix(scanner.Text(), "data: ")
+			if data == scanner.Text() {
+				continue
+			}
+			var j job
+			if err := json.Unmarshal([]byte(data), &j); err != nil {
+				t.Fatalf("Expected a job, but got: %s", data)
+			}
+			if j.Type == typ && (j.State == jobDone || j.State == jobFailed) {
+				return j
+			}
+		}
+		t.Fatalf("Expected the %s job to finish", typ)
+		return job{}
+	}
+
+	if status, body := request("POST", "/podcasts/1/sync"); status != http.StatusAccepted {
+		t.Fatalf("Expected the sync to be queued, but got: %d %s", status, body)
+	}
+	if j := waitFor(jobSync); j.State != jobDone || j.Result == nil || len(j.Result.Added) != 1 {
+		t.Fatalf("Expected the sync to find an episode, but got: %#v", j)
+	}
+
+	status, body = request("GET", "/podcasts/1/episodes")
+	if status != http.StatusOK || !strings.Contains(body, `"title":"Episode"`) {
+		t.Errorf("Expected the episodes, but got: %d %s", status, body)
+	}
+
+	if status, body := request("POST", "/podcasts/1/episodes/1/download"); status != http.StatusAccepted {
+		t.Fatalf("Expected the download to be queued, but got: %d %s", status, body)
+	}
+	if j := waitFor(jobDownload); j.State != jobDone || j.Bytes != 10 {
+		t.Fatalf("Expected the download to finish, but got: %#v", j)
+	}
+
+	if status, _ := request("POST", "/podcasts/1/episodes/1/download"); status != http.StatusConflict {
+		t.Errorf("Expected downloading again without force to conflict, but got: %d", status)
+	}
+	if status, body := request("POST", "/podcasts/1/episodes/1/download?force=true"); status != http.StatusAccepted {
+		t.Fatalf("Expected the forced download to be queued, but got: %d %s", status, body)
+	}
+	if j := waitFor(jobDownload); j.State != jobDone || j.Bytes != 10 {
+		t.Fatalf("Expected the forced download to finish, but got: %#v", j)
+	}
+	if status, _ := request("POST", "/podcasts/2/sync"); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown podcast to not be found, but got: %d", status)
+	}
+
+	status, body = request("GET", "/jobs")
+	if status != http.StatusOK || strings.Count(body, `"state":"done"`) != 3 {
+		t.Errorf("Expected all jobs to be done, but got: %d %s", status, body)
+	}
+}
+
+func TestDaemonListener(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", "192.168.1.10:7331", ":7331"} {
+		if listener, err := daemonListener(addr, ""); err == nil {
+			listener.Close()
+			t.Errorf("Expected listening on %s to be refused", addr)
+		}
+	}
+
+	listener, err := daemonListener("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatalf("Expected to listen on loopback, but got: %v", err)
+	}
+	listener.Close()
+}
+
+// TestDaemonJobsDuringSync polls the jobs while syncs run, for go test -race
+// to catch the worker and the API touching the same data.
+func TestDaemonJobsDuringSync(t *testing.T) {
+	var origin *httptest.Server
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// slow enough for the jobs to be polled while syncing
+		time.Sleep(10 * time.Millisecond)
+		io.WriteString(w, strings.Replace(serveFeed, "{{server}}", origin.URL, 1))
+	}))
+	defer origin.Close()
+
+	podcast := pcd.Podcast{
+		ID:      1,
+		Name:    "my podcast",
+		Feed:    origin.URL + "/feed",
+		Path:    t.TempDir(),
+		Artwork: pcd.Artwork{Cover: "none"},
+	}
+	d := newDaemon("token", func() []pcd.Podcast { return []pcd.Podcast{podcast} })
+	go d.work()
+	ts := httptest.NewServer(d)
+	defer ts.Close()
+
+	request := func(method, path string) string {
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	const syncs = 5
+	for i := 0; i < syncs; i++ {
+		request("POST", "/sync")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if body := request("GET", "/jobs"); strings.Count(body, `"state":"done"`) == syncs {
+			return
+		}
+	}
+	t.Fatalf("Expected the syncs to finish")
+}
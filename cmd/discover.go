@@ -0,0 +1,200 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kvannotten/pcd"
+	"github.com/kvannotten/pcd/directory"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// discoverCmd represents the discover command
+var discoverCmd = &cobra.Command{
+	Use:   "discover <query>",
+	Short: "Searches a podcast directory for feeds",
+	Long: `
+This command searches a podcast directory and lists the podcasts it finds, with
+their feed url and number of episodes. The iTunes Search API is used by
+default, Podcast Index needs an API key from https://api.podcastindex.org:
+
+discover:
+  directory: podcastindex
+  podcastindex_key: YOURKEY
+  podcastindex_secret: YOURSECRET
+
+--subscribe adds the podcasts with the given numbers in the list to your
+configuration, for example --subscribe 1,3. It takes the same selectors as
+download does. Their directories are created in --path, or in the directory
+of your other podcasts.`,
+	Args:        cobra.MinimumNArgs(1),
+	Annotations: map[string]string{configOptionalAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("directory")
+		if !cmd.Flags().Changed("directory") && viper.IsSet("discover.directory") {
+			name = viper.GetString("discover.directory")
+		}
+		baseURL, _ := cmd.Flags().GetString("base-url")
+		limit, _ := cmd.Flags().GetInt("limit")
+		subscribe, _ := cmd.Flags().GetString("subscribe")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		var d directory.Directory
+		switch strings.ToLower(name) {
+		case "itunes":
+			if baseURL == "" {
+				baseURL = viper.GetString("discover.itunes_url")
+			}
+			d = &directory.ITunes{BaseURL: baseURL}
+		case "podcastindex":
+			if baseURL == "" {
+				baseURL = viper.GetString("discover.podcastindex_url")
+			}
+			d = &directory.PodcastIndex{
+				BaseURL: baseURL,
+				Key:     viper.GetString("discover.podcastindex_key"),
+				Secret:  viper.GetString("discover.podcastindex_secret"),
+			}
+		default:
+			log.Fatalf("Unknown podcast directory %s, use itunes or podcastindex", name)
+		}
+
+		results, err := d.Search(strings.Join(args, " "), limit)
+		if err != nil {
+			log.Fatalf("Could not search for podcasts: %v", err)
+		}
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(results); err != nil {
+				log.Fatalf("Could not encode the results: %v", err)
+			}
+		} else {
+			printDiscovered(results)
+		}
+
+		if subscribe == "" {
+			return
+		}
+		if viper.ConfigFileUsed() == "" {
+			log.Fatal("No configuration found to subscribe in, create one first")
+		}
+
+		selected, err := selectDiscovered(results, subscribe)
+		if err != nil {
+			log.Fatalf("Could not select podcasts to subscribe to: %v", err)
+		}
+
+		path, _ := cmd.Flags().GetString("path")
+		if path == "" {
+			path = podcastsDir()
+		}
+		if path == "" {
+			log.Fatal("Could not tell where to put new podcasts, use --path")
+		}
+
+		for _, result := range selected {
+			var podcast *pcd.Podcast
+			err := editConfig("subscribing", func(config []byte) ([]byte, error) {
+				var err error
+				config, podcast, err = addPodcast(config, result.Title, result.Feed, path)
+				return config, err
+			})
+			if err != nil {
+				log.Printf("Could not subscribe to %s: %v", result.Title, err)
+				continue
+			}
+			fmt.Printf("Subscribed to %s as %s (%d), run 'pcd sync %d' to get its episodes\n", result.Title, podcast.Name, podcast.ID, podcast.ID)
+		}
+	},
+}
+
+func printDiscovered(results []directory.Podcast) {
+	if len(results) == 0 {
+		fmt.Println("No podcasts found")
+		return
+	}
+	for i, result := range results {
+		episodes := "? episodes"
+		if result.Episodes > 0 {
+			episodes = fmt.Sprintf("%d episodes", result.Episodes)
+		}
+		fmt.Printf("%3d  %s", i+1, result.Title)
+		if result.Author != "" {
+			fmt.Printf(" - %s", result.Author)
+		}
+		fmt.Printf(" (%s)\n     %s\n", episodes, result.Feed)
+	}
+}
+
+// selectDiscovered returns the results selected by their number in the
+// list, with an episode selector.
+func selectDiscovered(results []directory.Podcast, arg string) ([]directory.Podcast, error) {
+	sel, err := parseSelector(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the selector works on episodes, numbered like the list
+	episodes := make([]pcd.Episode, len(results))
+	for i, result := range results {
+		episodes[i] = pcd.Episode{ID: i + 1, Title: result.Title}
+	}
+	selected, err := sel.Select(episodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var podcasts []directory.Podcast
+	for _, episode := range selected {
+		podcasts = append(podcasts, results[episode.ID-1])
+	}
+	return podcasts, nil
+}
+
+// podcastsDir returns the directory new podcasts go in: discover.path from
+// the config, or the directory the last podcast is in.
+func podcastsDir() string {
+	if path := viper.GetString("discover.path"); path != "" {
+		return path
+	}
+	podcasts := findAll()
+	for i := len(podcasts) - 1; i >= 0; i-- {
+		if podcasts[i].Path != "" {
+			return filepath.Dir(podcasts[i].Path)
+		}
+	}
+	return ""
+}
+
+func init() {
+	rootCmd.AddCommand(discoverCmd)
+
+	discoverCmd.Flags().String("directory", "itunes", "The podcast directory to search, itunes or podcastindex")
+	discoverCmd.Flags().String("base-url", "", "The url of the directory API, for mirrors and testing")
+	discoverCmd.Flags().IntP("limit", "l", 20, "The maximum number of podcasts to list")
+	discoverCmd.Flags().StringP("subscribe", "s", "", "Add the podcasts with these numbers in the list to the configuration")
+	discoverCmd.Flags().StringP("path", "p", "", "The directory to create the directories of new podcasts in")
+	discoverCmd.Flags().Bool("json", false, "Output the results as JSON")
+}
@@ -17,10 +17,9 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -29,6 +28,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var forceDownload bool
+
 // downloadCmd represents the download command
 var downloadCmd = &cobra.Command{
 	Use:     "download <podcast> <episode_id>",
@@ -57,14 +58,29 @@ Available formats:
 
 Episode numbers: '1,5,105'
 Ranges: '2-15'
-Skipping: '!102,!121'
+Open ranges: '90-' (90 and later), '-10' (up to 10)
+Latest episodes: 'latest' or 'latest~5' (the 5 most recent)
+Everything: 'all'
+Titles: '/interview/' (case insensitive regular expression)
+Dates: '2024-01-15', '2024-01' or ranges like '2024-01..2024-03', '2024-02..'
+Skipping: '!102,!121' or any other format prefixed with '!', episode numbers
+that are listed on their own are never skipped
 
 Combining those as follow:
 
 pcd download gnu_open_world '1-30,40-47,!15,!17,!20,102'
+pcd download gnu_open_world 'all,!/trailer/'
 
 Make sure to use the single-quote on bash otherwise the !105 will expand your 
-bash history.`,
+bash history. Selectors starting with a '-' must follow a '--', like
+'pcd download gnu_open_world -- -10'.
+
+The filter flags of 'pcd ls' are available as well. Without an episode
+argument, every episode that passes the filter is downloaded. With one, only
+the episodes that are in the range and pass the filter are.
+
+pcd download gnu_open_world --last 3 --not-downloaded
+` + filterHelp,
 	Args: cobra.MinimumNArgs(1),
 	Run:  download,
 }
@@ -78,47 +94,74 @@ func download(cmd *cobra.Command, args []string) {
 		log.Fatalf("Could not find podcast with search: %s", args[0])
 	}
 
+	l, err := lockPodcast(podcast, "downloading into")
+	if err != nil {
+		log.Fatalf("Could not download: %v", err)
+	}
+	defer l.Release()
+
 	if err := podcast.Load(); err != nil {
 		log.Fatalf("Could not load podcast: %#v", err)
 	}
 
+	filter, err := filterFromFlags(cmd)
+	if err != nil {
+		log.Fatalf("Invalid filter: %v", err)
+	}
+
 	if len(args) < 2 {
-		// download latest
-		downloadEpisode(podcast, len(podcast.Episodes))
+		if !hasFilterFlags(cmd) {
+			// download latest
+			if len(podcast.Episodes) == 0 {
+				log.Fatalf("There are no episodes in this podcast.")
+			}
+			downloadEpisode(podcast, &podcast.Episodes[len(podcast.Episodes)-1])
+			return
+		}
+
+		for _, episode := range filter.Apply(podcast.Episodes) {
+			downloadEpisode(podcast, &episode)
+		}
 		return
 	}
 
-	episodes, err := parseRangeArg(args[1])
+	selector, err := parseSelector(args[1])
 	if err != nil {
-		log.Fatalf("Could not parse episode number %s: %#v", args[1], err)
+		if serr, ok := err.(*SelectorError); ok {
+			log.Fatalf("Could not parse episode selector:\n\t%s\n\t%s^\n%v", args[1], strings.Repeat(" ", serr.Pos-1), err)
+		}
+		log.Fatalf("Could not parse episode selector %s: %v", args[1], err)
 	}
 
-	for _, n := range episodes {
-		downloadEpisode(podcast, n)
+	episodes, err := selectEpisodes(podcast.Episodes, selector, filter)
+	if err != nil {
+		log.Fatalf("Could not select episodes: %v", err)
 	}
-}
 
-func downloadEpisode(podcast *pcd.Podcast, episodeN int) {
-	if episodeN > len(podcast.Episodes) {
-		log.Fatalf("There's only %d episodes in this podcast.", len(podcast.Episodes))
+	for _, episode := range episodes {
+		downloadEpisode(podcast, &episode)
 	}
+}
 
-	if episodeN < 1 {
-		log.Fatalf("A number from 1 to %d is required.", len(podcast.Episodes))
+func downloadEpisode(podcast *pcd.Podcast, episodeToDownload *pcd.Episode) {
+	if episodeToDownload.Downloaded && !forceDownload {
+		log.Printf("Already downloaded: '%s' episode %d of %s, use --force to download it again", episodeToDownload.Title, episodeToDownload.ID, podcast.Name)
+		return
 	}
 
-	episodeToDownload := podcast.Episodes[episodeN-1]
-	log.Printf("Started downloading: '%s' episode %d of %s", episodeToDownload.Title, episodeN, podcast.Name)
+	log.Printf("Started downloading: '%s' episode %d of %s", episodeToDownload.Title, episodeToDownload.ID, podcast.Name)
 
 	// RSS Feeds cannot be trusted to accurately or consistently report the length
 	// of the episode file. Instead, make a request for the header and use the
 	// Content-Length property to get an accurate size.
 	resp, err := http.Head(episodeToDownload.URL)
 	if err != nil {
+		runHook(podcast, pcd.EventError, episodeToDownload, "", err)
 		log.Fatalf("Request failed: %s\nError: %#v", episodeToDownload.Title, err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
+		runHook(podcast, pcd.EventError, episodeToDownload, "", fmt.Errorf("request failed: %s", resp.Status))
 		log.Fatalf("Request failed: %s\nError: %#v", episodeToDownload.Title, err)
 	}
 	size, _ := strconv.Atoi(resp.Header.Get("Content-Length"))
@@ -128,111 +171,73 @@ func downloadEpisode(podcast *pcd.Podcast, episodeN int) {
 	bar.ShowSpeed = true
 	bar.Start()
 
-	if err := episodeToDownload.Download(podcast.Path, bar, podcast.FilenameTemplate); err != nil {
+	if err := fetchEpisode(podcast, episodeToDownload, bar, forceDownload); err != nil {
 		log.Fatalf("Could not download episode: %#v", err)
 	}
 
 	bar.Finish()
 }
 
-func init() {
-	rootCmd.AddCommand(downloadCmd)
-
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// downloadCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// downloadCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-}
-
-// parseRangeArg parses episodes number with the following format
-// 1,2,3-5,!4 returns [1, 2, 3, 5]
-func parseRangeArg(arg string) ([]int, error) {
-	if len(arg) == 0 {
-		return nil, nil
+// fetchEpisode downloads the episode, mirroring the data into progress, and
+// writes the artwork, show notes, transcripts, chapters and tags configured
+// for the podcast. With replace, an earlier download of the episode is
+// replaced. The hooks run for the download or the error.
+func fetchEpisode(podcast *pcd.Podcast, episode *pcd.Episode, progress io.Writer, replace bool) error {
+	download := episode.Download
+	if replace {
+		download = episode.Redownload
 	}
-
-	// we try to convert the arg as a single episode
-	n, err := strconv.Atoi(arg)
-	if err == nil {
-		return []int{n}, nil
+	if err := download(podcast.Path, progress, podcast.FilenameTemplate); err != nil {
+		runHook(podcast, pcd.EventError, episode, "", err)
+		return err
 	}
 
-	// this map helps preventing duplicate
-	unique := make(map[int]bool)
-
-	// extract negative numbers !X
-	negatives := regexp.MustCompile(`!\d+`)
-	notWanted := negatives.FindAllString(arg, -1)
-
-	arg = negatives.ReplaceAllString(arg, "")
-
-	// extract ranges X-Y
-	rangesPattern := regexp.MustCompile(`\d+-\d+`)
-	ranges := rangesPattern.FindAllString(arg, -1)
-
-	arg = rangesPattern.ReplaceAllString(arg, "")
-
-	// extract the remaining single digit X
-	digitsPattern := regexp.MustCompile(`\d+`)
-	digits := digitsPattern.FindAllString(arg, -1)
-
-	for _, r := range ranges {
-		parts := strings.Split(r, "-")
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("range %s must have the format start-end", r)
+	if podcast.Artwork.Episodes {
+		if err := podcast.FetchEpisodeArtwork(episode); err != nil {
+			log.Printf("Could not download artwork of '%s': %v", episode.Title, err)
 		}
+	}
 
-		start, err := strconv.Atoi(parts[0])
-		if err != nil {
-			return nil, err
+	if podcast.ShowNotes != "" {
+		if err := podcast.WriteShowNotes(episode); err != nil {
+			log.Printf("Could not write show notes of '%s': %v", episode.Title, err)
 		}
+	}
 
-		end, err := strconv.Atoi(parts[1])
-		if err != nil {
-			return nil, err
+	if len(podcast.Transcripts) > 0 {
+		if err := podcast.FetchTranscript(episode); err != nil {
+			log.Printf("Could not download transcript of '%s': %v", episode.Title, err)
 		}
+	}
 
-		for i := start; i <= end; i++ {
-			// make sure it's wanted
-			wanted := true
-			for _, nw := range notWanted {
-				if fmt.Sprintf("!%d", i) == nw {
-					wanted = false
-					break
-				}
-			}
-
-			if !wanted {
-				continue
-			}
-
-			unique[i] = true
+	if podcast.Chapters {
+		if err := podcast.FetchChapters(episode); err != nil {
+			log.Printf("Could not download chapters of '%s': %v", episode.Title, err)
 		}
 	}
 
-	// let's add the remaining digits
-	for _, d := range digits {
-		i, err := strconv.Atoi(d)
-		if err != nil {
-			return nil, err
+	if podcast.Tag {
+		if err := podcast.TagEpisode(episode); err != nil {
+			log.Printf("Could not tag '%s': %v", episode.Title, err)
 		}
-
-		unique[i] = true
 	}
 
-	// we turn the unique map into the slice of episode numbers
-	var results []int
-	for k := range unique {
-		results = append(results, k)
-	}
+	runHook(podcast, pcd.EventDownload, episode, podcast.DownloadedFile(episode), nil)
+	return nil
+}
 
-	// we sort the result
-	sort.Ints(results)
+func init() {
+	rootCmd.AddCommand(downloadCmd)
+	addFilterFlags(downloadCmd)
+	downloadCmd.Flags().BoolVarP(&forceDownload, "force", "f", false, "Download episodes that were downloaded before")
+
+	// Here you will define your flags and configuration settings.
 
-	return results, nil
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// downloadCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// downloadCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
@@ -16,10 +16,45 @@
 package cmd
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/kvannotten/pcd"
 )
 
+// selectorEpisodes returns n episodes, one every day of 2024 starting on
+// January 1st.
+func selectorEpisodes(n int) []pcd.Episode {
+	var episodes []pcd.Episode
+	for i := 1; i <= n; i++ {
+		date := time.Date(2024, 1, i, 12, 0, 0, 0, time.UTC)
+		episodes = append(episodes, pcd.Episode{
+			ID:    i,
+			Title: fmt.Sprintf("Episode %d", i),
+			Date:  date.Format(time.RFC1123Z),
+		})
+	}
+	return episodes
+}
+
+func selectIDs(arg string, episodes []pcd.Episode) ([]int, error) {
+	s, err := parseSelector(arg)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := s.Select(episodes)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, episode := range selected {
+		ids = append(ids, episode.ID)
+	}
+	return ids, nil
+}
+
 func TestEpisodeRangeArgs(t *testing.T) {
 	cases := make(map[string][]int)
 	cases["1"] = []int{1}
@@ -32,8 +67,9 @@ func TestEpisodeRangeArgs(t *testing.T) {
 	cases["1-5,!3,4-6"] = []int{1, 2, 4, 5, 6}
 	cases[""] = nil
 
+	episodes := selectorEpisodes(200)
 	for arg, want := range cases {
-		got, err := parseRangeArg(arg)
+		got, err := selectIDs(arg, episodes)
 		if err != nil {
 			t.Error(err)
 		} else if reflect.DeepEqual(want, got) == false {
@@ -41,3 +77,105 @@ func TestEpisodeRangeArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestEpisodeSelectors(t *testing.T) {
+	cases := map[string][]int{
+		"latest":                 {10},
+		"latest~3":               {8, 9, 10},
+		"latest~3,!latest":       {8, 9},
+		"8-":                     {8, 9, 10},
+		"-3":                     {1, 2, 3},
+		"all,!2-9":               {1, 10},
+		"2024-01-04":             {4},
+		"2024-01-03..2024-01-05": {3, 4, 5},
+		"2024-01-09..":           {9, 10},
+		"..2024-01-02":           {1, 2},
+		"2024-01,!1-8":           {9, 10},
+		"2024-01..2024-02,!-9":   {10},
+		"/episode [12]$/":        {1, 2},
+		`/^episode 1\/?0$/, 3`:   {3, 10},
+		" 1 , 2 ":                {1, 2},
+		"LATEST":                 {10},
+	}
+
+	episodes := selectorEpisodes(10)
+	for arg, want := range cases {
+		got, err := selectIDs(arg, episodes)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", arg, err)
+		} else if !reflect.DeepEqual(want, got) {
+			t.Errorf("missmatch for %s: got %v want %v", arg, got, want)
+		}
+	}
+}
+
+func TestEpisodeSelectorSyntaxErrors(t *testing.T) {
+	cases := map[string]int{
+		"1,,2":          3,
+		"1-2-":          1,
+		"5-2":           1,
+		"0":             1,
+		"latest~":       8,
+		"latest~0":      8,
+		"newest":        1,
+		"/unterminated": 1,
+		"/(/":           1,
+		"1;2":           2,
+		"2024-13..":     1,
+		"2024-1..":      6,
+		"24..":          1,
+		"1.2":           2,
+		"!":             2,
+		"3 4":           3,
+	}
+
+	for arg, pos := range cases {
+		_, err := parseSelector(arg)
+		serr, ok := err.(*SelectorError)
+		if !ok {
+			t.Errorf("expected a syntax error for %q, got %v", arg, err)
+			continue
+		}
+		if serr.Pos != pos {
+			t.Errorf("expected error at position %d for %q, got %v", pos, arg, serr)
+		}
+	}
+}
+
+func TestEpisodeSelectorOutOfRange(t *testing.T) {
+	for _, arg := range []string{"11", "5-11", "11-"} {
+		if _, err := selectIDs(arg, selectorEpisodes(10)); err == nil {
+			t.Errorf("expected an error for %s", arg)
+		}
+	}
+}
+
+func TestEpisodeSelectorClamp(t *testing.T) {
+	cases := map[string][]int{
+		"11":        nil,
+		"5-11":      {5, 6, 7, 8, 9, 10},
+		"11-":       nil,
+		"1-20,!3-":  {1, 2},
+		"2,15-30,4": {2, 4},
+	}
+
+	for arg, want := range cases {
+		s, err := parseSelector(arg)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", arg, err)
+		}
+		s.clamp = true
+		selected, err := s.Select(selectorEpisodes(10))
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", arg, err)
+			continue
+		}
+		var got []int
+		for _, episode := range selected {
+			got = append(got, episode.ID)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("missmatch for %s: got %v want %v", arg, got, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kvannotten/pcd"
+	"github.com/spf13/cobra"
+)
+
+const filterHelp = `
+Episodes can be narrowed down with the following flags:
+
+  --since 2023-01-01      published on or after a date (YYYY-MM-DD, YYYY-MM or
+                          a relative age like 30d, 2w or 12h)
+  --until 2023-06         published on or before a date
+  --last 5                only the 5 most recent episodes (the last 5 in the
+                          feed when some have no date)
+  --downloaded            only episodes pcd downloaded
+  --not-downloaded        only episodes pcd did not download yet
+  --match 'interview'     only episodes with a title matching the regex
+  --sort date|title|size  order of the episodes
+  --reverse               reverse the order`
+
+// addFilterFlags registers the episode filter flags on cmd. Use
+// filterFromFlags to read them back.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().String("since", "", "Only episodes published on or after this date")
+	cmd.Flags().String("until", "", "Only episodes published on or before this date")
+	cmd.Flags().Int("last", 0, "Only the N most recent episodes")
+	cmd.Flags().Bool("downloaded", false, "Only episodes that were downloaded")
+	cmd.Flags().Bool("not-downloaded", false, "Only episodes that were not downloaded yet")
+	cmd.Flags().String("match", "", "Only episodes with a title matching this regular expression")
+	cmd.Flags().String("sort", "", "Sort episodes by date, title or size")
+	cmd.Flags().Bool("reverse", false, "Reverse the order of the episodes")
+}
+
+// filterFromFlags builds a filter from the flags registered by addFilterFlags.
+func filterFromFlags(cmd *cobra.Command) (*pcd.Filter, error) {
+	var filter pcd.Filter
+	var err error
+
+	flags := cmd.Flags()
+
+	since, _ := flags.GetString("since")
+	if since != "" {
+		if filter.Since, err = parseDateFlag(since, false); err != nil {
+			return nil, err
+		}
+	}
+
+	until, _ := flags.GetString("until")
+	if until != "" {
+		if filter.Until, err = parseDateFlag(until, true); err != nil {
+			return nil, err
+		}
+	}
+
+	filter.Last, _ = flags.GetInt("last")
+	if filter.Last < 0 {
+		return nil, fmt.Errorf("--last must be a positive number")
+	}
+
+	downloaded, _ := flags.GetBool("downloaded")
+	notDownloaded, _ := flags.GetBool("not-downloaded")
+	switch {
+	case downloaded && notDownloaded:
+		return nil, fmt.Errorf("--downloaded and --not-downloaded are mutually exclusive")
+	case downloaded, notDownloaded:
+		filter.Downloaded = &downloaded
+	}
+
+	match, _ := flags.GetString("match")
+	if match != "" {
+		if filter.Match, err = regexp.Compile(match); err != nil {
+			return nil, fmt.Errorf("invalid --match expression: %v", err)
+		}
+	}
+
+	sort, _ := flags.GetString("sort")
+	if filter.Sort, err = pcd.ParseSortKey(sort); err != nil {
+		return nil, err
+	}
+
+	filter.Reverse, _ = flags.GetBool("reverse")
+
+	return &filter, nil
+}
+
+// hasFilterFlags reports whether any of the filter flags was used.
+func hasFilterFlags(cmd *cobra.Command) bool {
+	for _, name := range []string{"since", "until", "last", "downloaded", "not-downloaded", "match", "sort", "reverse"} {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+var relativeDate = regexp.MustCompile(`^(\d+)([hdwmy])$`)
+
+// parseDateFlag parses absolute dates (YYYY-MM-DD, YYYY-MM) and relative ages
+// (12h, 30d, 2w, 6m, 1y). When end is true, an absolute date is moved to the
+// last moment of the day or month it describes, so it can serve as an
+// inclusive upper bound.
+func parseDateFlag(value string, end bool) (time.Time, error) {
+	if m := relativeDate.FindStringSubmatch(value); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		now := time.Now()
+		switch m[2] {
+		case "h":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "d":
+			return now.AddDate(0, 0, -n), nil
+		case "w":
+			return now.AddDate(0, 0, -7*n), nil
+		case "m":
+			return now.AddDate(0, -n, 0), nil
+		default:
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+
+	if t, err := time.Parse("2006-01", value); err == nil {
+		if end {
+			t = t.AddDate(0, 1, 0).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD, YYYY-MM or an age like 30d", strings.TrimSpace(value))
+}
@@ -0,0 +1,50 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/kvannotten/pcd"
+	"github.com/spf13/viper"
+)
+
+// runHook runs the hook for the event, preferring the podcast's own hooks over
+// the global 'hooks' entry of the configuration. Failing hooks are reported
+// but never stop pcd.
+func runHook(podcast *pcd.Podcast, event string, episode *pcd.Episode, file string, hookErr error) {
+	var global pcd.Hooks
+	if err := viper.UnmarshalKey("hooks", &global); err != nil {
+		log.Printf("Could not parse 'hooks' entry in config: %v", err)
+		return
+	}
+	hooks := global.Merge(podcast.Hooks)
+
+	e := pcd.HookEvent{
+		Event:     event,
+		PodcastID: podcast.ID,
+		Podcast:   podcast.Name,
+		Episode:   episode,
+		File:      file,
+	}
+	if hookErr != nil {
+		e.Error = hookErr.Error()
+	}
+
+	if err := hooks.Run(e); err != nil {
+		log.Printf("[%s] %s hook: %v", podcast.Name, event, err)
+	}
+}
@@ -27,7 +27,16 @@ var listCmd = &cobra.Command{
 	Use:     "list <podcast_id/podcast_name>",
 	Aliases: []string{"ls"},
 	Short:   "Lists all episodes of a podcast",
+	Long: `
+This command lists the episodes of a podcast, oldest first, or all podcasts
+from your configuration when no podcast is given.
+` + filterHelp,
 	Run: func(cmd *cobra.Command, args []string) {
+		filter, err := filterFromFlags(cmd)
+		if err != nil {
+			log.Fatalf("Invalid filter: %v", err)
+		}
+
 		if len(args) == 1 {
 			podcast, err := findPodcast(args[0])
 			if err != nil {
@@ -41,6 +50,7 @@ var listCmd = &cobra.Command{
 				log.Fatalf("Could not load podcast: %#v", err)
 			}
 
+			podcast.Episodes = filter.Apply(podcast.Episodes)
 			fmt.Print(podcast)
 		} else if len(args) == 0 {
 			all, err := cmd.Flags().GetBool("all")
@@ -56,8 +66,8 @@ var listCmd = &cobra.Command{
 				if !all {
 					fmt.Printf("\t%d - %-40s (%d episodes)\n", podcast.ID, podcast.Name, len(podcast.Episodes))
 				} else {
-					for i, episode := range podcast.Episodes {
-						fmt.Printf("%d;%d;%s\n", podcast.ID, i+1, episode.Title)
+					for _, episode := range filter.Apply(podcast.Episodes) {
+						fmt.Printf("%d;%d;%s\n", podcast.ID, episode.ID, episode.Title)
 					}
 				}
 			}
@@ -79,4 +89,5 @@ func init() {
 	// is called directly, e.g.:
 	// listCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	listCmd.Flags().BoolP("all", "a", false, "List all podcasts")
+	addFilterFlags(listCmd)
 }
@@ -0,0 +1,53 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/kvannotten/pcd"
+	"github.com/kvannotten/pcd/lock"
+	"github.com/spf13/viper"
+)
+
+// waitForLock is set by --wait, to wait for other pcd processes instead of
+// giving up.
+var waitForLock bool
+
+// lockPodcast takes the lock on the podcast's path for the action. When
+// another pcd holds it, it returns the *lock.LockedError, or with --wait says
+// so and waits for it.
+func lockPodcast(podcast *pcd.Podcast, action string) (*lock.Lock, error) {
+	l, err := podcast.Lock(action, false)
+	if lerr, ok := err.(*lock.LockedError); ok && waitForLock {
+		log.Printf("[%s] Waiting, %v", podcast.Name, lerr)
+		return podcast.Lock(action, true)
+	}
+	return l, err
+}
+
+// lockConfig takes the lock guarding writes to the configuration file, it
+// waits for other pcd processes writing to it.
+func lockConfig(action string) (*lock.Lock, error) {
+	path := viper.ConfigFileUsed() + ".lock"
+
+	l, err := lock.TryAcquire(path, action)
+	if lerr, ok := err.(*lock.LockedError); ok {
+		log.Printf("Waiting, %v", lerr)
+		return lock.Acquire(path, action)
+	}
+	return l, err
+}
@@ -0,0 +1,211 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kvannotten/pcd"
+	"github.com/kvannotten/pcd/playlist"
+	"github.com/spf13/cobra"
+)
+
+// playlistCmd represents the playlist command
+var playlistCmd = &cobra.Command{
+	Use:   "playlist <podcast|all> [episodes]",
+	Short: "Writes a playlist of downloaded episodes",
+	Long: `
+This command writes an M3U8, PLS or XSPF playlist of the episodes you
+downloaded, oldest first, to stdout or the file given with --output. The
+format is taken from the extension of the output file, or set with --format.
+
+pcd playlist biggest_problem -o biggest_problem.m3u8
+pcd playlist all --since 2w --format xspf
+
+The episodes can be picked with the same selectors as 'pcd download', for
+example 'latest~5' or '/interview/', and with the filter flags of 'pcd ls'.
+With 'all', selectors apply to every podcast on its own, and ranges past the
+last episode of a podcast take the episodes it has.
+
+The playlist holds the paths of the files. To play them on another device
+served by 'pcd serve', pass its url with --base-url.
+` + filterHelp,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		baseURL, _ := cmd.Flags().GetString("base-url")
+
+		formatName, _ := cmd.Flags().GetString("format")
+		var format playlist.Format
+		var err error
+		switch {
+		case formatName != "":
+			format, err = playlist.ParseFormat(formatName)
+		case output != "":
+			format, err = playlist.FormatOf(output)
+		default:
+			format = playlist.M3U8
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		filter, err := filterFromFlags(cmd)
+		if err != nil {
+			log.Fatalf("Invalid filter: %v", err)
+		}
+		if filter.Sort == pcd.SortNone {
+			filter.Sort = pcd.SortDate
+		}
+		// only what is on disk can be played
+		downloaded := true
+		filter.Downloaded = &downloaded
+
+		var sel *selector
+		if len(args) == 2 {
+			if sel, err = parseSelector(args[1]); err != nil {
+				log.Fatalf("Could not parse episode selector %s: %v", args[1], err)
+			}
+		}
+
+		var podcasts []pcd.Podcast
+		if args[0] == "all" {
+			podcasts = findAll()
+		} else {
+			podcast, err := findPodcast(args[0])
+			if err != nil {
+				log.Fatal("Could not perform search")
+			}
+			if podcast == nil {
+				log.Fatalf("Could not find podcast with search: %s", args[0])
+			}
+			podcasts = append(podcasts, *podcast)
+		}
+
+		// one podcast with fewer episodes must not spoil the playlist
+		if sel != nil && len(podcasts) > 1 {
+			sel.clamp = true
+		}
+
+		var items []playlistItem
+		for _, podcast := range podcasts {
+			if err := podcast.Load(); err != nil {
+				log.Fatalf("Could not load podcast %s: %#v", podcast.Name, err)
+			}
+			episodes, err := selectEpisodes(podcast.Episodes, sel, filter)
+			if err != nil {
+				log.Fatalf("Could not select episodes of %s: %v", podcast.Name, err)
+			}
+			for _, episode := range episodes {
+				file := podcast.DownloadedFile(&episode)
+				if file == "" {
+					continue
+				}
+				items = append(items, playlistItem{podcast: podcast.Name, episode: episode, file: file})
+			}
+		}
+
+		if len(podcasts) > 1 && filter.Sort == pcd.SortDate {
+			sort.SliceStable(items, func(i, j int) bool {
+				if filter.Reverse {
+					return items[i].episode.PubDate().After(items[j].episode.PubDate())
+				}
+				return items[i].episode.PubDate().Before(items[j].episode.PubDate())
+			})
+		}
+
+		entries := make([]playlist.Entry, 0, len(items))
+		for _, item := range items {
+			entries = append(entries, item.entry(len(podcasts) > 1, baseURL))
+		}
+
+		w := os.Stdout
+		if output != "" {
+			if w, err = os.Create(output); err != nil {
+				log.Fatalf("Could not create %s: %v", output, err)
+			}
+			defer w.Close()
+		}
+		if err := playlist.Write(w, format, entries); err != nil {
+			log.Fatalf("Could not write playlist: %v", err)
+		}
+	},
+}
+
+type playlistItem struct {
+	podcast string
+	episode pcd.Episode
+	file    string
+}
+
+// entry returns the playlist entry of the item, with the podcast's name in
+// the title when the playlist mixes podcasts. With a base url, the location
+// is the url 'pcd serve' serves the file on.
+func (i *playlistItem) entry(withPodcast bool, baseURL string) playlist.Entry {
+	entry := playlist.Entry{
+		Title:    i.episode.Title,
+		Location: i.file,
+		Duration: i.episode.Duration,
+	}
+	if withPodcast {
+		entry.Title = i.podcast + " - " + entry.Title
+	}
+	if baseURL != "" {
+		entry.Location = strings.TrimSuffix(baseURL, "/") + "/" + url.PathEscape(i.podcast) + "/" + url.PathEscape(filepath.Base(i.file))
+	} else if abs, err := filepath.Abs(i.file); err == nil {
+		entry.Location = abs
+	}
+	return entry
+}
+
+// selectEpisodes returns the episodes that pass the filter and, if given,
+// the selector, in the order of the filter.
+func selectEpisodes(episodes []pcd.Episode, sel *selector, filter *pcd.Filter) ([]pcd.Episode, error) {
+	if sel == nil {
+		return filter.Apply(episodes), nil
+	}
+
+	selected, err := sel.Select(episodes)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[int]bool)
+	for _, episode := range selected {
+		wanted[episode.ID] = true
+	}
+
+	var result []pcd.Episode
+	for _, episode := range filter.Apply(episodes) {
+		if wanted[episode.ID] {
+			result = append(result, episode)
+		}
+	}
+	return result, nil
+}
+
+func init() {
+	rootCmd.AddCommand(playlistCmd)
+	addFilterFlags(playlistCmd)
+
+	playlistCmd.Flags().StringP("output", "o", "", "File to write the playlist to instead of stdout")
+	playlistCmd.Flags().String("format", "", "Playlist format: m3u8, pls or xspf")
+	playlistCmd.Flags().String("base-url", "", "Url of 'pcd serve' to use instead of file paths")
+}
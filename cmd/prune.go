@@ -0,0 +1,92 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/kvannotten/pcd"
+	"github.com/spf13/cobra"
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune [podcast]",
+	Short: "Removes downloaded episodes according to the retention policy",
+	Long: `
+This command removes downloaded episodes that fall outside of the 'retention'
+policy of a podcast, or of all podcasts when none is given. For example:
+
+podcasts:
+  - id: 1
+    name: biggest_problem
+    ...
+    retention:
+      keep_last: 10    # keep the 10 most recent episodes
+      keep_days: 30    # keep episodes published in the last 30 days
+      max_size: 2GB    # keep at most 2GB of episodes, most recent first
+
+Only files that pcd downloaded itself are ever removed. Use --dry-run to see
+what would be removed.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatalf("Got an error while reading the dry-run flag")
+		}
+
+		var podcasts []pcd.Podcast
+		if len(args) == 1 {
+			podcast, err := findPodcast(args[0])
+			if err != nil {
+				log.Fatal("Could not perform search")
+			}
+			if podcast == nil {
+				log.Fatalf("Could not find podcast with search: %s", args[0])
+			}
+			podcasts = append(podcasts, *podcast)
+		} else {
+			podcasts = findAll()
+		}
+
+		for _, podcast := range podcasts {
+			l, err := lockPodcast(&podcast, "pruning")
+			if err != nil {
+				log.Printf("[%s] Could not prune podcast: %v", podcast.Name, err)
+				continue
+			}
+			pruned, err := podcast.Prune(dryRun)
+			l.Release()
+			for _, download := range pruned {
+				if dryRun {
+					fmt.Printf("[%s] Would remove %s (%s)\n", podcast.Name, download.Filename, pcd.FormatSize(download.Size))
+				} else {
+					fmt.Printf("[%s] Removed %s (%s)\n", podcast.Name, download.Filename, pcd.FormatSize(download.Size))
+				}
+			}
+			if err != nil {
+				log.Printf("[%s] Could not prune podcast: %v", podcast.Name, err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().BoolP("dry-run", "n", false, "Only show what would be removed")
+}
@@ -56,6 +56,7 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/pcd.yml)")
+	rootCmd.PersistentFlags().BoolVar(&waitForLock, "wait", false, "wait for other pcd processes working on the same podcast instead of giving up")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -80,13 +81,24 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
-	} else {
+		// stderr, so it doesn't get mixed up with output meant for scripts
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	} else if !configOptional() {
 		fmt.Println("No configuration found. Please create one first. Have a look at https://github.com/kvannotten/pcd#usage to see how.")
 		os.Exit(1)
 	}
 }
 
+// configOptional tells whether the command being run works without a
+// configuration, which commands mark with the annotation
+// configOptionalAnnotation.
+func configOptional() bool {
+	cmd, _, err := rootCmd.Find(os.Args[1:])
+	return err == nil && cmd.Annotations[configOptionalAnnotation] == "true"
+}
+
+const configOptionalAnnotation = "config-optional"
+
 func findPodcast(idOrName string) (*pcd.Podcast, error) {
 	id, err := strconv.Atoi(idOrName)
 	if err != nil {
@@ -0,0 +1,228 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/kvannotten/pcd"
+	"github.com/kvannotten/pcd/lock"
+	"github.com/spf13/cobra"
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run --once | --every <interval>",
+	Short: "Syncs, downloads new episodes and prunes in one go",
+	Long: `
+This command syncs all podcasts, downloads the episodes picked by each
+podcast's 'auto_download' policy and applies its retention policy. Run it once
+from cron with --once, or keep it running with --every:
+
+pcd run --once
+pcd run --every 1h
+
+The policy is either 'new', for the episodes that show up in the feed after
+the first sync, or an episode selector like the ones of 'pcd download':
+
+podcasts:
+  - id: 1
+    name: biggest_problem
+    ...
+    auto_download: new            # or 'latest~3', 'all,!/trailer/', ...
+
+Episodes that were downloaded before, even when they were pruned since, are
+never downloaded again. Podcasts without a policy are only synced.
+
+A lock file keeps overlapping runs from colliding, a run that finds another
+one going skips its turn.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		once, _ := cmd.Flags().GetBool("once")
+		every, _ := cmd.Flags().GetDuration("every")
+		switch {
+		case once && every > 0:
+			log.Fatal("--once and --every are mutually exclusive")
+		case !once && every <= 0:
+			log.Fatal("Please use --once, or --every with an interval like 1h")
+		}
+
+		lockFile, _ := cmd.Flags().GetString("lock-file")
+		if lockFile == "" {
+			lockFile = defaultLockFile()
+		}
+
+		if once {
+			if err := runOnce(lockFile); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			if err := runOnce(lockFile); err != nil {
+				log.Print(err)
+			}
+			log.Printf("Next run at %s", time.Now().Add(every).Format("15:04:05"))
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	},
+}
+
+// defaultLockFile is the lock file in the user's cache directory, falling
+// back to the temporary directory.
+func defaultLockFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "pcd-run.lock")
+	}
+	dir = filepath.Join(dir, "pcd")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return filepath.Join(os.TempDir(), "pcd-run.lock")
+	}
+	return filepath.Join(dir, "run.lock")
+}
+
+// runOnce syncs, downloads and prunes every podcast while holding the lock.
+func runOnce(lockFile string) error {
+	l, err := lock.TryAcquire(lockFile, "running")
+	if err != nil {
+		if _, ok := err.(*lock.LockedError); ok {
+			return fmt.Errorf("%v, skipping this run", err)
+		}
+		return err
+	}
+	defer l.Release()
+
+	for _, podcast := range findAll() {
+		runPodcast(&podcast)
+	}
+	return nil
+}
+
+// runPodcast syncs the podcast, downloads the episodes its auto_download
+// policy picks and prunes it. Errors are logged, so one broken feed doesn't
+// hold up the others.
+func runPodcast(podcast *pcd.Podcast) {
+	log.Printf("[%s] Syncing...", podcast.Name)
+	result, err := syncPodcast(podcast)
+	if err != nil {
+		log.Printf("[%s] Could not sync podcast: %v", podcast.Name, err)
+		return
+	}
+	printNewEpisodes(podcast.Name, result)
+
+	l, err := lockPodcast(podcast, "downloading into")
+	if err != nil {
+		log.Printf("[%s] Could not download: %v", podcast.Name, err)
+		return
+	}
+	defer l.Release()
+
+	if err := podcast.Load(); err != nil {
+		log.Printf("[%s] Could not load podcast: %v", podcast.Name, err)
+		return
+	}
+
+	episodes, err := autoDownloadEpisodes(podcast, result)
+	if err != nil {
+		log.Printf("[%s] Invalid auto_download policy %q: %v", podcast.Name, podcast.AutoDownload, err)
+	}
+	for _, episode := range episodes {
+		log.Printf("[%s] Downloading '%s'", podcast.Name, episode.Title)
+		if err := fetchEpisode(podcast, &episode, nil, false); err != nil {
+			log.Printf("[%s] Could not download '%s': %v", podcast.Name, episode.Title, err)
+		}
+	}
+
+	pruned, err := podcast.Prune(false)
+	for _, download := range pruned {
+		fmt.Printf("[%s] Removed %s (%s)\n", podcast.Name, download.Filename, pcd.FormatSize(download.Size))
+	}
+	if err != nil {
+		log.Printf("[%s] Could not prune podcast: %v", podcast.Name, err)
+	}
+}
+
+// autoDownloadEpisodes returns the loaded episodes of the podcast that its
+// auto_download policy picks and that were never downloaded.
+func autoDownloadEpisodes(podcast *pcd.Podcast, result *pcd.SyncResult) ([]pcd.Episode, error) {
+	var picked []pcd.Episode
+
+	switch podcast.AutoDownload {
+	case "":
+		return nil, nil
+	case "new":
+		// the first sync would download the whole back catalog
+		if result.First {
+			return nil, nil
+		}
+		added := make(map[string]bool)
+		for _, episode := range result.Added {
+			added[episode.Key()] = true
+		}
+		for _, episode := range podcast.Episodes {
+			if added[episode.Key()] {
+				picked = append(picked, episode)
+			}
+		}
+	default:
+		sel, err := parseSelector(podcast.AutoDownload)
+		if err != nil {
+			return nil, err
+		}
+		if picked, err = sel.Select(podcast.Episodes); err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := pcd.LoadState(podcast.Path)
+	if err != nil {
+		return nil, err
+	}
+	var episodes []pcd.Episode
+	for _, episode := range picked {
+		// pruned episodes have a record as well
+		if state.Find(&episode) == nil {
+			episodes = append(episodes, episode)
+		}
+	}
+	return episodes, nil
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	runCmd.Flags().Bool("once", false, "Run once and exit")
+	runCmd.Flags().Duration("every", 0, "Run at this interval, like 30m or 1h")
+	runCmd.Flags().String("lock-file", "", "Lock file shared by the runs (default is in the user cache directory)")
+}
@@ -0,0 +1,80 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/kvannotten/pcd"
+)
+
+func TestAutoDownloadEpisodes(t *testing.T) {
+	episodes := selectorEpisodes(5)
+	for i := range episodes {
+		episodes[i].GUID = episodes[i].Title
+	}
+
+	path := t.TempDir()
+	state := &pcd.State{Downloads: []pcd.Download{
+		{GUID: episodes[4].GUID, Filename: "5.mp3"},
+		{GUID: episodes[3].GUID, Filename: "4.mp3", Pruned: true},
+	}}
+	if err := state.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	added := &pcd.SyncResult{Diff: pcd.Diff{Added: episodes[2:]}}
+	table := []struct {
+		policy   string
+		result   *pcd.SyncResult
+		expected []int
+	}{
+		{"", added, nil},
+		{"new", added, []int{3}},
+		{"new", &pcd.SyncResult{Diff: added.Diff, First: true}, nil},
+		{"latest~3", added, []int{3}},
+		{"all", added, []int{1, 2, 3}},
+		{"1-2", added, []int{1, 2}},
+	}
+
+	for _, e := range table {
+		podcast := &pcd.Podcast{Path: path, AutoDownload: e.policy, Episodes: episodes}
+		got, err := autoDownloadEpisodes(podcast, e.result)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, but got: %#v", e.policy, err)
+		}
+
+		var ids []int
+		for _, episode := range got {
+			ids = append(ids, episode.ID)
+		}
+		if len(ids) != len(e.expected) {
+			t.Errorf("Expected %#v for %q, but got: %#v", e.expected, e.policy, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != e.expected[i] {
+				t.Errorf("Expected %#v for %q, but got: %#v", e.expected, e.policy, ids)
+				break
+			}
+		}
+	}
+
+	podcast := &pcd.Podcast{Path: path, AutoDownload: "newest", Episodes: episodes}
+	if _, err := autoDownloadEpisodes(podcast, added); err == nil {
+		t.Errorf("Expected an invalid policy to fail")
+	}
+}
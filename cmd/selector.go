@@ -0,0 +1,553 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kvannotten/pcd"
+)
+
+// An episode selector is a comma separated list of terms, each optionally
+// prefixed with '!' to exclude the episodes it matches:
+//
+//	12         episode 12
+//	10-20      episodes 10 up to and including 20
+//	90-        episode 90 and everything after it
+//	-10        episodes 1 up to and including 10
+//	latest     the most recent episode
+//	latest~5   the 5 most recent episodes
+//	all        every episode
+//	/regex/    episodes with a title matching the (case insensitive) regex
+//	2024-01-15                 episodes published on that day
+//	2024-01                    episodes published in January 2024
+//	2024-01..2024-03           episodes published from January to March 2024
+//	2024-01-15..  ..2023-12    open ended date ranges
+//
+// The result is every episode matched by a term without '!' minus the
+// episodes matched by a term with '!', except for episodes listed by their
+// number on their own: those are always selected, like they were before
+// there were selectors.
+
+// SelectorError describes a syntax error in an episode selector. Pos is the
+// 1-based position of the offending character.
+type SelectorError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SelectorError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokWord
+	tokRegex
+	tokDash
+	tokDots
+	tokTilde
+	tokBang
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokRegex:
+		return fmt.Sprintf("/%s/", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func tokenize(arg string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(arg); {
+		c := arg[i]
+		pos := i + 1
+
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(arg) && arg[i] >= '0' && arg[i] <= '9' {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, arg[start:i], pos})
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(arg) && (arg[i] >= 'a' && arg[i] <= 'z' || arg[i] >= 'A' && arg[i] <= 'Z') {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, strings.ToLower(arg[start:i]), pos})
+		case c == '/':
+			var sb strings.Builder
+			i++
+			for ; i < len(arg) && arg[i] != '/'; i++ {
+				if arg[i] == '\\' && i+1 < len(arg) && arg[i+1] == '/' {
+					i++
+				}
+				sb.WriteByte(arg[i])
+			}
+			if i == len(arg) {
+				return nil, &SelectorError{pos, "unterminated regular expression"}
+			}
+			i++
+			tokens = append(tokens, token{tokRegex, sb.String(), pos})
+		case c == '.':
+			if i+1 >= len(arg) || arg[i+1] != '.' {
+				return nil, &SelectorError{pos, "expected '..'"}
+			}
+			i += 2
+			tokens = append(tokens, token{tokDots, "..", pos})
+		case c == '-':
+			i++
+			tokens = append(tokens, token{tokDash, "-", pos})
+		case c == '~':
+			i++
+			tokens = append(tokens, token{tokTilde, "~", pos})
+		case c == '!':
+			i++
+			tokens = append(tokens, token{tokBang, "!", pos})
+		case c == ',':
+			i++
+			tokens = append(tokens, token{tokComma, ",", pos})
+		default:
+			return nil, &SelectorError{pos, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+
+	return append(tokens, token{tokEOF, "", len(arg) + 1}), nil
+}
+
+// term matches episodes. The episodes are in feed order, oldest first.
+type term interface {
+	match(episodes []pcd.Episode) (map[int]bool, error)
+}
+
+type allTerm struct{}
+
+type latestTerm struct{ n int }
+
+type rangeTerm struct {
+	// 0 means open ended
+	start, end int
+}
+
+type regexTerm struct{ re *regexp.Regexp }
+
+type dateTerm struct {
+	// zero means open ended
+	since, until time.Time
+}
+
+type selector struct {
+	include []term
+	exclude []term
+	// clamp makes ranges past the last episode select the episodes that
+	// exist, for selectors that apply to several podcasts.
+	clamp bool
+}
+
+type selectorParser struct {
+	tokens []token
+	pos    int
+}
+
+// parseSelector parses an episode selector. An empty arg yields a nil
+// selector, which selects nothing.
+func parseSelector(arg string) (*selector, error) {
+	if strings.TrimSpace(arg) == "" {
+		return nil, nil
+	}
+
+	tokens, err := tokenize(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &selectorParser{tokens: tokens}
+	s := &selector{}
+
+	for {
+		negate := false
+		if p.peek().kind == tokBang {
+			p.next()
+			negate = true
+		}
+
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			s.exclude = append(s.exclude, t)
+		} else {
+			s.include = append(s.include, t)
+		}
+
+		switch tok := p.next(); tok.kind {
+		case tokComma:
+			continue
+		case tokEOF:
+			return s, nil
+		default:
+			return nil, p.unexpected(tok, "',' or end of input")
+		}
+	}
+}
+
+func (p *selectorParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *selectorParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *selectorParser) unexpected(tok token, want string) error {
+	return &SelectorError{tok.pos, fmt.Sprintf("expected %s, found %s", want, tok)}
+}
+
+func (p *selectorParser) number() (int, token, error) {
+	tok := p.next()
+	if tok.kind != tokNumber {
+		return 0, tok, p.unexpected(tok, "a number")
+	}
+	n, err := strconv.Atoi(tok.text)
+	if err != nil {
+		return 0, tok, &SelectorError{tok.pos, "number too large"}
+	}
+	return n, tok, nil
+}
+
+func (p *selectorParser) term() (term, error) {
+	tok := p.peek()
+
+	switch tok.kind {
+	case tokWord:
+		p.next()
+		switch tok.text {
+		case "all":
+			return allTerm{}, nil
+		case "latest":
+			if p.peek().kind != tokTilde {
+				return latestTerm{1}, nil
+			}
+			p.next()
+			n, numTok, err := p.number()
+			if err != nil {
+				return nil, err
+			}
+			if n < 1 {
+				return nil, &SelectorError{numTok.pos, "latest~N requires N to be at least 1"}
+			}
+			return latestTerm{n}, nil
+		default:
+			return nil, &SelectorError{tok.pos, fmt.Sprintf("unknown keyword %q, expected 'all' or 'latest'", tok.text)}
+		}
+	case tokRegex:
+		p.next()
+		re, err := regexp.Compile("(?i)" + tok.text)
+		if err != nil {
+			return nil, &SelectorError{tok.pos, fmt.Sprintf("invalid regular expression: %v", err)}
+		}
+		return regexTerm{re}, nil
+	case tokDash:
+		p.next()
+		end, _, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		return p.checkRange(tok, rangeTerm{1, end})
+	case tokDots:
+		p.next()
+		until, err := p.date(true)
+		if err != nil {
+			return nil, err
+		}
+		return dateTerm{until: until}, nil
+	case tokNumber:
+		return p.numberOrDate()
+	default:
+		return nil, p.unexpected(tok, "an episode number, range, date, /regex/, 'latest' or 'all'")
+	}
+}
+
+// numberOrDate parses the terms starting with a number: single episodes,
+// (open) ranges and dates. A date is told apart from a range by having three
+// parts (YYYY-MM-DD), by being followed by '..' or by looking like YYYY-MM,
+// which is never a valid range as it would end before it starts.
+func (p *selectorParser) numberOrDate() (term, error) {
+	start := p.pos
+	first, firstTok, err := p.number()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind == tokDots {
+		return nil, &SelectorError{firstTok.pos, "dates must be written as YYYY-MM or YYYY-MM-DD"}
+	}
+
+	if p.peek().kind != tokDash {
+		return p.checkRange(firstTok, rangeTerm{first, first})
+	}
+	p.next()
+
+	if p.peek().kind != tokNumber {
+		// open ended range: 90-
+		return p.checkRange(firstTok, rangeTerm{first, 0})
+	}
+	second, _, err := p.number()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind == tokDash || p.peek().kind == tokDots || isMonth(firstTok, p.tokens[p.pos-1]) {
+		p.pos = start
+		since, err := p.date(false)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokDots {
+			// a single day or month
+			p.pos = start
+			until, _ := p.date(true)
+			return dateTerm{since, until}, nil
+		}
+		p.next()
+
+		switch p.peek().kind {
+		case tokComma, tokEOF:
+			return dateTerm{since: since}, nil
+		}
+		until, err := p.date(true)
+		if err != nil {
+			return nil, err
+		}
+		if until.Before(since) {
+			return nil, &SelectorError{firstTok.pos, "date range ends before it starts"}
+		}
+		return dateTerm{since, until}, nil
+	}
+
+	return p.checkRange(firstTok, rangeTerm{first, second})
+}
+
+func isMonth(year, month token) bool {
+	return len(year.text) == 4 && len(month.text) == 2
+}
+
+func (p *selectorParser) checkRange(tok token, r rangeTerm) (term, error) {
+	if r.start < 1 || (r.end != 0 && r.end < 1) {
+		return nil, &SelectorError{tok.pos, "episode numbers start at 1"}
+	}
+	if r.end != 0 && r.end < r.start {
+		return nil, &SelectorError{tok.pos, fmt.Sprintf("range %d-%d ends before it starts", r.start, r.end)}
+	}
+	return r, nil
+}
+
+// date parses YYYY-MM or YYYY-MM-DD. When end is true the last moment of the
+// month or day is returned.
+func (p *selectorParser) date(end bool) (time.Time, error) {
+	var parts []string
+
+	year, yearTok, err := p.number()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(yearTok.text) != 4 {
+		return time.Time{}, &SelectorError{yearTok.pos, "dates must be written as YYYY-MM or YYYY-MM-DD"}
+	}
+	parts = append(parts, fmt.Sprintf("%04d", year))
+
+	for len(parts) < 3 {
+		if len(parts) == 2 && p.peek().kind != tokDash {
+			break
+		}
+		if tok := p.next(); tok.kind != tokDash {
+			return time.Time{}, p.unexpected(tok, "'-' in date")
+		}
+		_, tok, err := p.number()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if len(tok.text) != 2 {
+			return time.Time{}, &SelectorError{tok.pos, "months and days must have two digits"}
+		}
+		parts = append(parts, tok.text)
+	}
+
+	layout := "2006-01-02"[:len(strings.Join(parts, "-"))]
+	t, err := time.Parse(layout, strings.Join(parts, "-"))
+	if err != nil {
+		return time.Time{}, &SelectorError{yearTok.pos, fmt.Sprintf("invalid date %s", strings.Join(parts, "-"))}
+	}
+
+	if end {
+		if len(parts) == 2 {
+			t = t.AddDate(0, 1, 0)
+		} else {
+			t = t.AddDate(0, 0, 1)
+		}
+		t = t.Add(-time.Nanosecond)
+	}
+
+	return t, nil
+}
+
+// Select returns the episodes matched by the selector, in feed order.
+func (s *selector) Select(episodes []pcd.Episode) ([]pcd.Episode, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	included := make(map[int]bool)
+	for _, t := range s.include {
+		matched, err := s.match(t, episodes)
+		if err != nil {
+			return nil, err
+		}
+		for id := range matched {
+			included[id] = true
+		}
+	}
+
+	for _, t := range s.exclude {
+		matched, err := s.match(t, episodes)
+		if err != nil {
+			return nil, err
+		}
+		for id := range matched {
+			delete(included, id)
+		}
+	}
+
+	// an episode that is asked for by its number wins over an exclusion
+	for _, t := range s.include {
+		if r, ok := t.(rangeTerm); ok && r.start == r.end {
+			matched, err := s.match(r, episodes)
+			if err != nil {
+				return nil, err
+			}
+			for id := range matched {
+				included[id] = true
+			}
+		}
+	}
+
+	var result []pcd.Episode
+	for _, episode := range episodes {
+		if included[episode.ID] {
+			result = append(result, episode)
+		}
+	}
+
+	return result, nil
+}
+
+func (allTerm) match(episodes []pcd.Episode) (map[int]bool, error) {
+	matched := make(map[int]bool)
+	for _, episode := range episodes {
+		matched[episode.ID] = true
+	}
+	return matched, nil
+}
+
+func (t latestTerm) match(episodes []pcd.Episode) (map[int]bool, error) {
+	matched := make(map[int]bool)
+	start := len(episodes) - t.n
+	if start < 0 {
+		start = 0
+	}
+	for _, episode := range episodes[start:] {
+		matched[episode.ID] = true
+	}
+	return matched, nil
+}
+
+// match matches the episodes with the term. Ranges past the last episode
+// are an error, unless the selector clamps them.
+func (s *selector) match(t term, episodes []pcd.Episode) (map[int]bool, error) {
+	if r, ok := t.(rangeTerm); ok && s.clamp {
+		return r.existing(episodes), nil
+	}
+	return t.match(episodes)
+}
+
+func (t rangeTerm) match(episodes []pcd.Episode) (map[int]bool, error) {
+	end := t.end
+	if end == 0 {
+		end = len(episodes)
+	}
+	if t.start > len(episodes) || end > len(episodes) {
+		return nil, fmt.Errorf("there's only %d episodes in this podcast", len(episodes))
+	}
+	return t.existing(episodes), nil
+}
+
+// existing returns the episodes in the range that exist.
+func (t rangeTerm) existing(episodes []pcd.Episode) map[int]bool {
+	matched := make(map[int]bool)
+	for _, episode := range episodes {
+		if episode.ID >= t.start && (t.end == 0 || episode.ID <= t.end) {
+			matched[episode.ID] = true
+		}
+	}
+	return matched
+}
+
+func (t regexTerm) match(episodes []pcd.Episode) (map[int]bool, error) {
+	matched := make(map[int]bool)
+	for _, episode := range episodes {
+		if t.re.MatchString(episode.Title) {
+			matched[episode.ID] = true
+		}
+	}
+	return matched, nil
+}
+
+func (t dateTerm) match(episodes []pcd.Episode) (map[int]bool, error) {
+	filter := pcd.Filter{Since: t.since, Until: t.until}
+
+	matched := make(map[int]bool)
+	for _, episode := range filter.Apply(episodes) {
+		matched[episode.ID] = true
+	}
+	return matched, nil
+}
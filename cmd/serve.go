@@ -0,0 +1,196 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/kvannotten/pcd"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves feeds of the downloaded episodes over HTTP",
+	Long: `
+This command starts a web server with a feed for every podcast, listing the
+episodes you downloaded, so other devices can subscribe to your local copies.
+
+  http://<addr>/                          lists the feeds
+  http://<addr>/<podcast>/feed.xml        the feed of a podcast
+  http://<addr>/<podcast>/<file>          the episodes, with Range support
+
+Only files pcd downloaded are served. The urls in the feeds are built from the
+Host the feed was requested on. Behind a reverse proxy, set the url the server
+is reachable on with --base-url, or in the config:
+
+serve:
+  addr: ":8080"
+  base_url: https://home.example.com/podcasts`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, _ := cmd.Flags().GetString("addr")
+		if !cmd.Flags().Changed("addr") && viper.IsSet("serve.addr") {
+			addr = viper.GetString("serve.addr")
+		}
+		baseURL, _ := cmd.Flags().GetString("base-url")
+		if baseURL == "" {
+			baseURL = viper.GetString("serve.base_url")
+		}
+
+		log.Printf("Serving feeds on %s", addr)
+		if err := http.ListenAndServe(addr, feedHandler(findAll(), baseURL)); err != nil {
+			log.Fatalf("Could not serve: %v", err)
+		}
+	},
+}
+
+// exportFeedCmd represents the export-feed command
+var exportFeedCmd = &cobra.Command{
+	Use:   "export-feed <podcast> --base-url <url>",
+	Short: "Writes a feed of the downloaded episodes of a podcast",
+	Long: `
+This command writes a feed listing the downloaded episodes of a podcast, for
+when the podcast's path is served by another web server. The base url is the
+url the podcast's path is reachable on:
+
+pcd export-feed biggest_problem --base-url https://nas.local/biggest_problem -o feed.xml`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		baseURL, _ := cmd.Flags().GetString("base-url")
+		if baseURL == "" {
+			log.Fatal("Please provide the url the podcast is served on with --base-url")
+		}
+		output, _ := cmd.Flags().GetString("output")
+
+		podcast, err := findPodcast(args[0])
+		if err != nil {
+			log.Fatal("Could not perform search")
+		}
+		if podcast == nil {
+			log.Fatalf("Could not find podcast with search: %s", args[0])
+		}
+		if err := podcast.Load(); err != nil {
+			log.Fatalf("Could not load podcast: %#v", err)
+		}
+
+		w := os.Stdout
+		if output != "" {
+			if w, err = os.Create(output); err != nil {
+				log.Fatalf("Could not create %s: %v", output, err)
+			}
+			defer w.Close()
+		}
+
+		if err := podcast.WriteLocalFeed(w, baseURL); err != nil {
+			log.Fatalf("Could not write feed: %v", err)
+		}
+	},
+}
+
+// feedHandler serves the local feeds and the downloaded files of podcasts.
+// Without a baseURL the urls in the feeds point back at the requested host.
+func feedHandler(podcasts []pcd.Podcast, baseURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		base := strings.TrimSuffix(baseURL, "/")
+		if base == "" {
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			base = scheme + "://" + r.Host
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		if path == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, podcast := range podcasts {
+				fmt.Fprintf(w, "%s\t%s/%s/feed.xml\n", podcast.Name, base, url.PathEscape(podcast.Name))
+			}
+			return
+		}
+
+		name, file, _ := strings.Cut(path, "/")
+		var podcast *pcd.Podcast
+		for i := range podcasts {
+			if podcasts[i].Name == name {
+				podcast = &podcasts[i]
+				break
+			}
+		}
+		if podcast == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if file == "feed.xml" {
+			// load on every request, so new downloads show up
+			p := *podcast
+			if err := p.Load(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+			if err := p.WriteLocalFeed(w, base+"/"+url.PathEscape(name)); err != nil {
+				log.Printf("[%s] Could not write feed: %v", name, err)
+			}
+			return
+		}
+
+		fpath := podcast.ServedFile(file)
+		if fpath == "" {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := os.Open(fpath)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// ServeContent takes care of Range and conditional requests
+		http.ServeContent(w, r, file, info.ModTime(), f)
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(exportFeedCmd)
+
+	serveCmd.Flags().String("addr", "localhost:8080", "Address to listen on")
+	serveCmd.Flags().String("base-url", "", "Url the server is reachable on, used in the feeds")
+
+	exportFeedCmd.Flags().String("base-url", "", "Url the podcast's path is reachable on")
+	exportFeedCmd.Flags().StringP("output", "o", "", "File to write the feed to instead of stdout")
+}
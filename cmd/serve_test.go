@@ -0,0 +1,95 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kvannotten/pcd"
+)
+
+const serveFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Title of Podcast</title>
+<item>
+    <title>Episode</title>
+    <enclosure url="{{server}}/episode.mp3" type="audio/mpeg" length="10"></enclosure>
+    <pubDate>Thu, 21 Dec 2016 16:01:07 +0000</pubDate>
+    <guid>episode-1</guid>
+</item>
+</channel>
+</rss>`
+
+func TestFeedHandler(t *testing.T) {
+	var origin *httptest.Server
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed" {
+			io.WriteString(w, strings.Replace(serveFeed, "{{server}}", origin.URL, 1))
+			return
+		}
+		io.WriteString(w, "0123456789")
+	}))
+	defer origin.Close()
+
+	podcast := pcd.Podcast{Name: "my podcast", Feed: origin.URL + "/feed", Path: t.TempDir(), Artwork: pcd.Artwork{Cover: "none"}}
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if err := podcast.Episodes[0].Download(podcast.Path, nil, ""); err != nil {
+		t.Fatalf("Expected to be able to download, but got: %#v", err)
+	}
+
+	ts := httptest.NewServer(feedHandler([]pcd.Podcast{podcast}, ""))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/my%20podcast/feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if expected := `url="` + ts.URL + `/my%20podcast/episode.mp3"`; !strings.Contains(string(body), expected) {
+		t.Errorf("Expected the feed to contain %s, but got: %s", expected, body)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/my%20podcast/episode.mp3", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "2345" {
+		t.Errorf("Expected a partial response with %q, but got: %d %q", "2345", resp.StatusCode, body)
+	}
+
+	for _, path := range []string{"/my%20podcast/.state", "/my%20podcast/.feed", "/other/feed.xml"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected %s to not be found, but got: %d", path, resp.StatusCode)
+		}
+	}
+}
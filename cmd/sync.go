@@ -16,7 +16,10 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/kvannotten/pcd"
 	"github.com/spf13/cobra"
@@ -25,9 +28,38 @@ import (
 
 // syncCmd represents the sync command
 var syncCmd = &cobra.Command{
-	Use:     "sync",
+	Use:     "sync [podcast]",
 	Aliases: []string{"s"},
 	Short:   "Syncs your podcasts",
+	Args:    cobra.MaximumNArgs(1),
+	Long: `
+This command fetches the feeds of all your podcasts, or only of the podcast
+given, caches their episodes and lists the episodes that are new since the
+previous sync. Feeds can be file:// urls of feeds on disk as well.
+
+With --from-file the feed of the podcast is read from a file instead, or from
+stdin with -, which is handy for testing the feeds of your own publishing
+pipeline:
+
+pcd sync --from-file feed.xml biggest_problem
+
+Episodes are matched on their guid, so when a publisher moves episodes to a new
+url or renames them, pcd still knows which ones you downloaded. Use --diff to
+see which episodes were added, removed or modified since the previous sync.
+
+With --json a single JSON document describing the changes of every podcast is
+written to stdout, which is handy for notification scripts:
+
+[{"id": 1, "podcast": "biggest_problem", "added": [...], "removed": [...], ...}]
+
+Episodes that disappear from the feed are kept and listed as archived, so
+the history of what you downloaded isn't lost when a publisher trims the feed.
+With --replace, or replace_episodes in the configuration of a podcast, they
+are dropped instead.
+
+Feeds that moved permanently, by a 301 or 308 redirect or an
+itunes:new-feed-url, are reported with a warning. With --update-urls the new
+url is written into the feed entry of the podcast in your configuration.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		var podcasts []pcd.Podcast
 
@@ -35,16 +67,199 @@ var syncCmd = &cobra.Command{
 			log.Fatalf("Could not parse 'podcasts' entry in config: %v", err)
 		}
 
+		fromFile, err := cmd.Flags().GetString("from-file")
+		if err != nil {
+			log.Fatalf("Got an error while reading the from-file flag")
+		}
+		if fromFile != "" && len(args) == 0 {
+			log.Fatal("--from-file needs the podcast to sync")
+		}
+		if len(args) == 1 {
+			podcast, err := findPodcast(args[0])
+			if err != nil {
+				log.Fatal("Could not perform search")
+			}
+			if podcast == nil {
+				log.Fatalf("Could not find podcast with search: %s", args[0])
+			}
+			podcasts = []pcd.Podcast{*podcast}
+		}
+
+		showDiff, err := cmd.Flags().GetBool("diff")
+		if err != nil {
+			log.Fatalf("Got an error while reading the diff flag")
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			log.Fatalf("Got an error while reading the json flag")
+		}
+		updateURLs, err := cmd.Flags().GetBool("update-urls")
+		if err != nil {
+			log.Fatalf("Got an error while reading the update-urls flag")
+		}
+		replace, err := cmd.Flags().GetBool("replace")
+		if err != nil {
+			log.Fatalf("Got an error while reading the replace flag")
+		}
+
+		var reports []syncReport
 		for _, podcast := range podcasts {
+			podcast.ReplaceEpisodes = podcast.ReplaceEpisodes || replace
 			log.Printf("[%s] Syncing...", podcast.Name)
-			if err := podcast.Sync(); err != nil {
+			var result *pcd.SyncResult
+			if fromFile != "" {
+				result, err = syncPodcastFromFile(&podcast, fromFile)
+			} else {
+				result, err = syncPodcast(&podcast)
+			}
+
+			report := syncReport{ID: podcast.ID, Podcast: podcast.Name, SyncResult: result}
+			if err != nil {
 				log.Printf("[%s] Could not sync podcast: %v", podcast.Name, err)
+				report.Error = err.Error()
+			}
+			reports = append(reports, report)
+
+			if err == nil && result.MovedTo != "" && updateURLs {
+				if err := updateFeedURL(&podcast, result.MovedTo); err != nil {
+					log.Printf("[%s] Could not update the feed url: %v", podcast.Name, err)
+				} else {
+					log.Printf("[%s] Updated the feed url to %s", podcast.Name, result.MovedTo)
+				}
+			}
+
+			if err != nil || asJSON {
 				continue
 			}
+			if showDiff {
+				printDiff(podcast.Name, &result.Diff)
+			} else {
+				printNewEpisodes(podcast.Name, result)
+			}
+		}
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(reports); err != nil {
+				log.Fatalf("Could not encode sync results: %v", err)
+			}
 		}
 	},
 }
 
+// syncPodcast syncs the podcast under its lock and runs the hooks for the new
+// episodes, or for the error. A podcast locked by another pcd is not synced.
+func syncPodcast(podcast *pcd.Podcast) (*pcd.SyncResult, error) {
+	return syncPodcastWith(podcast, podcast.Sync)
+}
+
+// syncPodcastFromFile syncs the podcast like syncPodcast, with the feed read
+// from the file, or from stdin for "-".
+func syncPodcastFromFile(podcast *pcd.Podcast, path string) (*pcd.SyncResult, error) {
+	return syncPodcastWith(podcast, func() (*pcd.SyncResult, error) {
+		if path == "-" {
+			return podcast.SyncFrom(os.Stdin)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return podcast.SyncFrom(f)
+	})
+}
+
+func syncPodcastWith(podcast *pcd.Podcast, sync func() (*pcd.SyncResult, error)) (*pcd.SyncResult, error) {
+	l, err := lockPodcast(podcast, "syncing")
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	result, err := sync()
+	if err != nil {
+		runHook(podcast, pcd.EventError, nil, "", err)
+		return nil, err
+	}
+	for _, warning := range result.Warnings {
+		log.Printf("[%s] Warning: %s", podcast.Name, warning)
+	}
+	if result.MovedTo != "" {
+		log.Printf("[%s] Warning: the feed moved permanently to %s, 'pcd sync --update-urls' updates your configuration", podcast.Name, result.MovedTo)
+	}
+
+	// the first sync would announce the whole back catalog
+	if !result.First {
+		for _, episode := range result.Added {
+			runHook(podcast, pcd.EventSyncNewEpisode, &episode, "", nil)
+		}
+	}
+
+	return result, nil
+}
+
+// updateFeedURL sets the feed of the podcast in the configuration file.
+func updateFeedURL(podcast *pcd.Podcast, feed string) error {
+	return editConfig("updating the feed of "+podcast.Name, func(config []byte) ([]byte, error) {
+		return setPodcastFeed(config, podcast.Name, feed)
+	})
+}
+
+// syncReport is the JSON representation of the sync of a podcast.
+type syncReport struct {
+	ID      int    `json:"id"`
+	Podcast string `json:"podcast"`
+	Error   string `json:"error,omitempty"`
+	*pcd.SyncResult
+}
+
 func init() {
 	rootCmd.AddCommand(syncCmd)
+
+	syncCmd.Flags().Bool("diff", false, "Report added, removed and modified episodes")
+	syncCmd.Flags().Bool("json", false, "Write the changes of all podcasts to stdout as JSON")
+	syncCmd.Flags().String("from-file", "", "Read the feed of the podcast from a file, or - for stdin")
+	syncCmd.Flags().Bool("replace", false, "Drop the episodes that disappeared from the feed instead of archiving them")
+	syncCmd.Flags().Bool("update-urls", false, "Update the feed urls of podcasts that moved permanently in the configuration")
+}
+
+func printNewEpisodes(name string, result *pcd.SyncResult) {
+	switch {
+	case result.First:
+		fmt.Printf("[%s] Found %d episodes\n", name, len(result.Added))
+	case len(result.Added) == 0:
+		fmt.Printf("[%s] No new episodes\n", name)
+	default:
+		fmt.Printf("[%s] %d new episode(s):\n", name, len(result.Added))
+		for _, episode := range result.Added {
+			fmt.Printf("  %-4d %s (%s)\n", episode.ID, episode.Title, episode.Date)
+		}
+	}
+}
+
+func printDiff(name string, diff *pcd.Diff) {
+	if diff.IsEmpty() {
+		fmt.Printf("[%s] No changes\n", name)
+		return
+	}
+
+	fmt.Printf("[%s] %d added, %d removed, %d modified\n", name, len(diff.Added), len(diff.Removed), len(diff.Modified))
+	for _, episode := range diff.Added {
+		fmt.Printf("  + %s (%s)\n", episode.Title, episode.Date)
+	}
+	for _, episode := range diff.Removed {
+		fmt.Printf("  - %s (%s)\n", episode.Title, episode.Date)
+	}
+	for _, change := range diff.Modified {
+		fmt.Printf("  ~ %s\n", change.New.Title)
+		if change.TitleChanged() {
+			fmt.Printf("      title: %s -> %s\n", change.Old.Title, change.New.Title)
+		}
+		if change.URLChanged() {
+			fmt.Printf("      url:   %s -> %s\n", change.Old.URL, change.New.URL)
+		}
+	}
 }
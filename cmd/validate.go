@@ -0,0 +1,175 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kvannotten/pcd"
+	"github.com/kvannotten/pcd/validate"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Exit codes of the validate command
+const (
+	exitProblems   = 1
+	exitCouldNotGo = 2
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate <podcast|url|file>",
+	Short: "Checks a feed for problems",
+	Long: `
+This command checks a feed for the problems that make podcast apps trip over
+it: items without a title or an enclosure, unparsable dates, duplicate guids,
+urls that aren't absolute, media files that can't be downloaded and charset
+problems. The feed is the one of a podcast in your configuration, a url, or
+a file ("-" for stdin).
+
+The exit code tells how it went, for use in CI:
+
+0  no errors were found
+1  errors were found, or warnings with --strict
+2  the feed could not be read
+
+The media files are checked with HEAD requests, --head=false skips that.`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{configOptionalAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		head, _ := cmd.Flags().GetBool("head")
+		strict, _ := cmd.Flags().GetBool("strict")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		content, contentType, err := readFeedSource(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read %s: %v\n", args[0], err)
+			os.Exit(exitCouldNotGo)
+		}
+
+		var opts validate.Options
+		opts.ContentType = contentType
+		if head {
+			opts.Client = &http.Client{Timeout: 30 * time.Second}
+		}
+		report := validate.Feed(content, opts)
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				log.Fatalf("Could not encode the report: %v", err)
+			}
+		} else {
+			for _, problem := range report.Problems {
+				fmt.Println(problem)
+			}
+			fmt.Printf("%d error(s), %d warning(s)\n", report.Count(validate.Error), report.Count(validate.Warning))
+		}
+
+		if report.Count(validate.Error) > 0 || (strict && report.Count(validate.Warning) > 0) {
+			os.Exit(exitProblems)
+		}
+	},
+}
+
+// readFeedSource reads the feed of a podcast, at a url or in a file, and
+// returns it with the Content-Type it was served with.
+func readFeedSource(source string) ([]byte, string, error) {
+	if source == "-" {
+		content, err := io.ReadAll(os.Stdin)
+		return content, "", err
+	}
+	if u, err := url.Parse(source); err == nil && u.Scheme != "" && len(u.Scheme) > 1 {
+		return readFeedURL(source, "", "")
+	}
+	if _, err := os.Stat(source); err == nil {
+		content, err := os.ReadFile(source)
+		return content, "", err
+	}
+
+	// findPodcast exits when nothing matches, which isn't the exit code
+	// for that here
+	var matches []pcd.Podcast
+	if viper.ConfigFileUsed() != "" {
+		for _, podcast := range findAll() {
+			if strconv.Itoa(podcast.ID) == source || strings.Contains(strings.ToLower(podcast.Name), strings.ToLower(source)) {
+				matches = append(matches, podcast)
+			}
+		}
+	}
+	if len(matches) != 1 {
+		return nil, "", fmt.Errorf("no such podcast, url or file")
+	}
+	return readFeedURL(matches[0].Feed, matches[0].Username, matches[0].Password)
+}
+
+// readFeedURL reads the feed at the http, https or file url.
+func readFeedURL(feed, username, password string) ([]byte, string, error) {
+	u, err := url.Parse(feed)
+	if err != nil {
+		return nil, "", err
+	}
+	switch u.Scheme {
+	case "file":
+		path, err := pcd.FeedFilePath(feed)
+		if err != nil {
+			return nil, "", err
+		}
+		content, err := os.ReadFile(path)
+		return content, "", err
+	case "http", "https":
+	default:
+		return nil, "", fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequest("GET", feed, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := (&http.Client{Timeout: time.Minute}).Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("the server responded with %s", resp.Status)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	return content, resp.Header.Get("Content-Type"), err
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().Bool("head", true, "Check that the media files can be downloaded")
+	validateCmd.Flags().Bool("strict", false, "Fail on warnings as well")
+	validateCmd.Flags().Bool("json", false, "Write the problems to stdout as JSON")
+}
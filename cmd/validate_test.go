@@ -0,0 +1,53 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadFeedURLFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "feed.xml"), []byte("<rss/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// /C:/... on windows
+	path := "/" + strings.TrimPrefix(filepath.ToSlash(filepath.Join(dir, "feed.xml")), "/")
+	for _, feed := range []string{"file:feed.xml", "file://" + path, "file://localhost" + path} {
+		content, _, err := readFeedURL(feed, "", "")
+		if err != nil {
+			t.Errorf("Expected to read %s, but got: %v", feed, err)
+		} else if string(content) != "<rss/>" {
+			t.Errorf("Expected %#v, but got: %#v", "<rss/>", string(content))
+		}
+	}
+
+	if _, _, err := readFeedURL("file://nas"+path, "", ""); err == nil {
+		t.Errorf("Expected an error for a file on another host")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+// Diff describes how the episodes of a feed changed between two syncs.
+// Episodes are matched on their Key, so an episode that keeps its guid but
+// moves to another url or gets a new title is modified rather than removed
+// and added. Episodes that got archived count as removed.
+type Diff struct {
+	Added    []Episode `json:"added"`
+	Removed  []Episode `json:"removed"`
+	Modified []Change  `json:"modified"`
+}
+
+// Change is an episode whose url or title changed.
+type Change struct {
+	Old Episode `json:"old"`
+	New Episode `json:"new"`
+}
+
+// URLChanged reports whether the episode's enclosure moved.
+func (c *Change) URLChanged() bool {
+	return c.Old.URL != c.New.URL
+}
+
+// TitleChanged reports whether the episode was renamed.
+func (c *Change) TitleChanged() bool {
+	return c.Old.Title != c.New.Title
+}
+
+// IsEmpty reports whether nothing changed.
+func (d *Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// DiffEpisodes compares the cached episodes in old with the episodes of a
+// fresh sync in new.
+func DiffEpisodes(old, new []Episode) Diff {
+	var diff Diff
+
+	previous := make(map[string]Episode, len(old))
+	for _, episode := range old {
+		previous[episode.Key()] = episode
+	}
+
+	current := make(map[string]bool, len(new))
+	for _, episode := range new {
+		key := episode.Key()
+		current[key] = true
+
+		before, ok := previous[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, episode)
+		case episode.Archived:
+			if !before.Archived {
+				diff.Removed = append(diff.Removed, episode)
+			}
+		case before.URL != episode.URL || before.Title != episode.Title:
+			diff.Modified = append(diff.Modified, Change{Old: before, New: episode})
+		}
+	}
+
+	for _, episode := range old {
+		if !current[episode.Key()] {
+			diff.Removed = append(diff.Removed, episode)
+		}
+	}
+
+	return diff
+}
+
+// applyChanges updates the download records of modified episodes, so a
+// publisher moving to a new CDN or renaming an episode doesn't make pcd
+// forget it downloaded the episode.
+func (s *State) applyChanges(changes []Change) bool {
+	updated := false
+
+	for _, change := range changes {
+		download := s.Find(&change.Old)
+		if download == nil {
+			continue
+		}
+
+		if change.URLChanged() {
+			download.PreviousURLs = append(download.PreviousURLs, download.URL)
+			download.URL = change.New.URL
+		}
+		download.Title = change.New.Title
+		updated = true
+	}
+
+	return updated
+}
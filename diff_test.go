@@ -0,0 +1,187 @@
+package pcd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDiffEpisodes(t *testing.T) {
+	old := []Episode{
+		{GUID: "1", Title: "one", URL: "http://old.example.com/1.mp3"},
+		{GUID: "2", Title: "two", URL: "http://old.example.com/2.mp3"},
+		{GUID: "3", Title: "three", URL: "http://old.example.com/3.mp3"},
+	}
+	new := []Episode{
+		{GUID: "2", Title: "two", URL: "http://new.example.com/2.mp3"},
+		{GUID: "3", Title: "three (remastered)", URL: "http://old.example.com/3.mp3"},
+		{GUID: "4", Title: "four", URL: "http://new.example.com/4.mp3"},
+	}
+
+	diff := DiffEpisodes(old, new)
+
+	if len(diff.Added) != 1 || diff.Added[0].GUID != "4" {
+		t.Errorf("Expected episode 4 to be added, but got %#v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].GUID != "1" {
+		t.Errorf("Expected episode 1 to be removed, but got %#v", diff.Removed)
+	}
+	if len(diff.Modified) != 2 {
+		t.Fatalf("Expected 2 modified episodes, but got %#v", diff.Modified)
+	}
+	if !diff.Modified[0].URLChanged() || diff.Modified[0].TitleChanged() {
+		t.Errorf("Expected only the url of episode 2 to change")
+	}
+	if diff.Modified[1].URLChanged() || !diff.Modified[1].TitleChanged() {
+		t.Errorf("Expected only the title of episode 3 to change")
+	}
+
+	if diff := DiffEpisodes(new, new); !diff.IsEmpty() {
+		t.Errorf("Expected no differences, but got %#v", diff)
+	}
+}
+
+func TestSyncKeepsDownloadsOfMovedEpisodes(t *testing.T) {
+	feed := Podcastfeed
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(feed))
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Feed: ts.URL, Path: randomPath(t)}
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+
+	state := &State{}
+	state.add(Download{
+		GUID:     podcast.Episodes[0].GUID,
+		URL:      podcast.Episodes[0].URL,
+		Filename: "podcast.mp3",
+	})
+	if err := state.Save(podcast.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	feed = strings.Replace(Podcastfeed, "http://example.com/podcast-1/podcast.mp3", "http://cdn.example.com/podcast.mp3", 1)
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if err := podcast.Load(); err != nil {
+		t.Fatalf("Expected to be able to load, but got: %#v", err)
+	}
+
+	if !podcast.Episodes[0].Downloaded {
+		t.Errorf("Expected the moved episode to still be downloaded")
+	}
+
+	state, _ = LoadState(podcast.Path)
+	download := state.Find(&podcast.Episodes[0])
+	if download.URL != "http://cdn.example.com/podcast.mp3" {
+		t.Errorf("Expected the download to have the new url, but got %s", download.URL)
+	}
+	if len(download.PreviousURLs) != 1 || download.PreviousURLs[0] != "http://example.com/podcast-1/podcast.mp3" {
+		t.Errorf("Expected the old url to be recorded, but got %v", download.PreviousURLs)
+	}
+}
+
+func TestSyncResult(t *testing.T) {
+	feed := Podcastfeed
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(feed))
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Feed: ts.URL, Path: randomPath(t)}
+	result, err := podcast.Sync()
+	if err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if !result.First || len(result.Added) != 1 {
+		t.Errorf("Expected the first sync to add every episode, but got %#v", result)
+	}
+
+	result, _ = podcast.Sync()
+	if result.First || !result.IsEmpty() {
+		t.Errorf("Expected nothing to change, but got %#v", result)
+	}
+
+	feed = strings.Replace(Podcastfeed, "<!--END REPEAT-->", `<item>
+    <title>A new episode</title>
+    <enclosure url="http://example.com/podcast-2/podcast.mp3" type="audio/mpeg" length="1024"></enclosure>
+    <pubDate>Thu, 28 Dec 2016 16:01:07 +0000</pubDate>
+    <guid>http://example.com/podcast-2</guid>
+</item>`, 1)
+	result, _ = podcast.Sync()
+	if len(result.Added) != 1 || result.Added[0].Title != "A new episode" {
+		t.Errorf("Expected the new episode to be added, but got %#v", result.Added)
+	}
+}
+
+func TestSyncArchivesRemovedEpisodes(t *testing.T) {
+	feed := Podcastfeed
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(feed))
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Feed: ts.URL, Path: randomPath(t)}
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	title := podcast.Episodes[0].Title
+
+	feed = strings.Replace(Podcastfeed, "<guid>http://example.com/podcast-1</guid>", "<guid>new</guid>", 1)
+	result, err := podcast.Sync()
+	if err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if len(result.Removed) != 1 || len(result.Added) != 1 {
+		t.Errorf("Expected 1 removed and 1 added episode, but got: %#v", result.Diff)
+	}
+	if len(podcast.Episodes) != 2 {
+		t.Fatalf("Expected the removed episode to be kept, but got: %#v", podcast.Episodes)
+	}
+	archived := 0
+	for _, episode := range podcast.Episodes {
+		if episode.Archived {
+			archived++
+		}
+	}
+	if archived != 1 {
+		t.Errorf("Expected 1 archived episode, but got %d", archived)
+	}
+
+	// it is only reported as removed once
+	result, err = podcast.Sync()
+	if err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if !result.IsEmpty() {
+		t.Errorf("Expected no differences, but got: %#v", result.Diff)
+	}
+
+	// and comes back when it reappears
+	feed = Podcastfeed
+	result, err = podcast.Sync()
+	if err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if len(result.Added) != 0 {
+		t.Errorf("Expected the episode not to be added again, but got: %#v", result.Added)
+	}
+	for _, episode := range podcast.Episodes {
+		if episode.Title == title && episode.GUID != "new" && episode.Archived {
+			t.Errorf("Expected the episode to not be archived anymore")
+		}
+	}
+
+	podcast.ReplaceEpisodes = true
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if len(podcast.Episodes) != 1 {
+		t.Errorf("Expected the episodes to be replaced, but got: %#v", podcast.Episodes)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package directory searches podcast directories for feeds, the iTunes
+// Search API and Podcast Index.
+package directory
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Default base urls of the directories
+const (
+	ITunesURL       = "https://itunes.apple.com"
+	PodcastIndexURL = "https://api.podcastindex.org/api/1.0"
+)
+
+var (
+	ErrSearchFailed  = errors.New("Could not search the podcast directory")
+	ErrMissingAPIKey = errors.New("Podcast Index needs an API key and secret")
+)
+
+// Podcast is a podcast found in a directory.
+type Podcast struct {
+	Title  string `json:"title"`
+	Author string `json:"author,omitempty"`
+	Feed   string `json:"feed"`
+	// Episodes is the number of episodes according to the directory, zero
+	// when it doesn't say.
+	Episodes int `json:"episodes,omitempty"`
+}
+
+// Directory is a podcast directory.
+type Directory interface {
+	// Search returns at most limit podcasts matching the query.
+	Search(query string, limit int) ([]Podcast, error)
+}
+
+// ITunes searches the iTunes Search API.
+type ITunes struct {
+	// BaseURL defaults to ITunesURL.
+	BaseURL string
+	Client  *http.Client
+}
+
+type itunesResults struct {
+	Results []struct {
+		CollectionName string `json:"collectionName"`
+		ArtistName     string `json:"artistName"`
+		FeedURL        string `json:"feedUrl"`
+		TrackCount     int    `json:"trackCount"`
+	} `json:"results"`
+}
+
+// Search implements Directory.
+func (d *ITunes) Search(query string, limit int) ([]Podcast, error) {
+	params := url.Values{}
+	params.Set("media", "podcast")
+	params.Set("term", query)
+	params.Set("limit", strconv.Itoa(limit))
+
+	req, err := http.NewRequest("GET", baseURL(d.BaseURL, ITunesURL)+"/search?"+params.Encode(), nil)
+	if err != nil {
+		log.Printf("Could not create request: %#v", err)
+		return nil, ErrSearchFailed
+	}
+
+	var results itunesResults
+	if err := getJSON(d.Client, req, &results); err != nil {
+		return nil, err
+	}
+
+	var podcasts []Podcast
+	for _, r := range results.Results {
+		// not every result is a podcast with a public feed
+		if r.FeedURL == "" {
+			continue
+		}
+		podcasts = append(podcasts, Podcast{
+			Title:    r.CollectionName,
+			Author:   r.ArtistName,
+			Feed:     r.FeedURL,
+			Episodes: r.TrackCount,
+		})
+	}
+	return podcasts, nil
+}
+
+// PodcastIndex searches Podcast Index, which needs an API key from
+// https://api.podcastindex.org.
+type PodcastIndex struct {
+	// BaseURL defaults to PodcastIndexURL.
+	BaseURL string
+	Key     string
+	Secret  string
+	Client  *http.Client
+}
+
+type podcastIndexResults struct {
+	Feeds []struct {
+		Title        string `json:"title"`
+		Author       string `json:"author"`
+		URL          string `json:"url"`
+		EpisodeCount int    `json:"episodeCount"`
+	} `json:"feeds"`
+}
+
+// Search implements Directory.
+func (d *PodcastIndex) Search(query string, limit int) ([]Podcast, error) {
+	if d.Key == "" || d.Secret == "" {
+		return nil, ErrMissingAPIKey
+	}
+
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("max", strconv.Itoa(limit))
+
+	req, err := http.NewRequest("GET", baseURL(d.BaseURL, PodcastIndexURL)+"/search/byterm?"+params.Encode(), nil)
+	if err != nil {
+		log.Printf("Could not create request: %#v", err)
+		return nil, ErrSearchFailed
+	}
+
+	// https://podcastindex-org.github.io/docs-api/#auth
+	date := strconv.FormatInt(time.Now().Unix(), 10)
+	hash := sha1.Sum([]byte(d.Key + d.Secret + date))
+	req.Header.Set("X-Auth-Key", d.Key)
+	req.Header.Set("X-Auth-Date", date)
+	req.Header.Set("Authorization", hex.EncodeToString(hash[:]))
+
+	var results podcastIndexResults
+	if err := getJSON(d.Client, req, &results); err != nil {
+		return nil, err
+	}
+
+	var podcasts []Podcast
+	for _, f := range results.Feeds {
+		podcasts = append(podcasts, Podcast{
+			Title:    f.Title,
+			Author:   f.Author,
+			Feed:     f.URL,
+			Episodes: f.EpisodeCount,
+		})
+	}
+	return podcasts, nil
+}
+
+func baseURL(base, fallback string) string {
+	if base == "" {
+		base = fallback
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	req.Header.Set("User-Agent", "pcd")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Could not search: %#v", err)
+		return ErrSearchFailed
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Could not search, the directory responded with %s", resp.Status)
+		return ErrSearchFailed
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		log.Printf("Could not decode search results: %#v", err)
+		return ErrSearchFailed
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package directory
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestITunesSearch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" {
+			t.Errorf("Expected %#v, but got: %#v", "/search", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("term") != "biggest problem" || q.Get("media") != "podcast" || q.Get("limit") != "5" {
+			t.Errorf("Expected a podcast search for the term with a limit, but got: %#v", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"resultCount": 2, "results": [
+			{"collectionName": "The Biggest Problem", "artistName": "Some Author", "feedUrl": "http://example.com/biggest.rss", "trackCount": 42},
+			{"collectionName": "No Feed", "artistName": "Nobody", "trackCount": 3}
+		]}`))
+	}))
+	defer ts.Close()
+
+	d := &ITunes{BaseURL: ts.URL + "/"}
+	podcasts, err := d.Search("biggest problem", 5)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+
+	expected := []Podcast{{Title: "The Biggest Problem", Author: "Some Author", Feed: "http://example.com/biggest.rss", Episodes: 42}}
+	if !reflect.DeepEqual(podcasts, expected) {
+		t.Errorf("Expected %#v, but got: %#v", expected, podcasts)
+	}
+}
+
+func TestPodcastIndexSearch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search/byterm" || r.URL.Query().Get("q") != "biggest" {
+			t.Errorf("Expected a search by term, but got: %#v", r.URL.String())
+		}
+		hash := sha1.Sum([]byte("key" + "secret" + r.Header.Get("X-Auth-Date")))
+		if r.Header.Get("X-Auth-Key") != "key" || r.Header.Get("Authorization") != hex.EncodeToString(hash[:]) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"status": "true", "feeds": [
+			{"title": "The Biggest Problem", "author": "Some Author", "url": "http://example.com/biggest.rss", "episodeCount": 42}
+		]}`))
+	}))
+	defer ts.Close()
+
+	d := &PodcastIndex{BaseURL: ts.URL, Key: "key", Secret: "secret"}
+	podcasts, err := d.Search("biggest", 10)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+
+	expected := []Podcast{{Title: "The Biggest Problem", Author: "Some Author", Feed: "http://example.com/biggest.rss", Episodes: 42}}
+	if !reflect.DeepEqual(podcasts, expected) {
+		t.Errorf("Expected %#v, but got: %#v", expected, podcasts)
+	}
+
+	d.Secret = "wrong"
+	if _, err := d.Search("biggest", 10); err != ErrSearchFailed {
+		t.Errorf("Expected %#v, but got: %#v", ErrSearchFailed, err)
+	}
+
+	d.Key = ""
+	if _, err := d.Search("biggest", 10); err != ErrMissingAPIKey {
+		t.Errorf("Expected %#v, but got: %#v", ErrMissingAPIKey, err)
+	}
+}
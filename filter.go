@@ -0,0 +1,159 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SortKey is the episode attribute a Filter orders by.
+type SortKey string
+
+const (
+	SortNone  SortKey = ""
+	SortDate  SortKey = "date"
+	SortTitle SortKey = "title"
+	SortSize  SortKey = "size"
+)
+
+var ErrInvalidSortKey = errors.New("Invalid sort key, use one of date, title or size")
+
+// ParseSortKey converts the user provided s into a SortKey.
+func ParseSortKey(s string) (SortKey, error) {
+	switch key := SortKey(strings.ToLower(s)); key {
+	case SortNone, SortDate, SortTitle, SortSize:
+		return key, nil
+	default:
+		return SortNone, ErrInvalidSortKey
+	}
+}
+
+// Filter selects and orders episodes. The zero value keeps all episodes in
+// the order they were given.
+type Filter struct {
+	// Since and Until bound the publication date, both inclusive. Episodes
+	// without a parsable date never match a bound.
+	Since time.Time
+	Until time.Time
+
+	// Last keeps only the n most recent episodes that match the other
+	// criteria. When some of them have no parsable date, the last n in the
+	// order they were given are kept instead.
+	Last int
+
+	// Downloaded keeps only downloaded (true) or not yet downloaded (false)
+	// episodes. nil keeps both.
+	Downloaded *bool
+
+	// Match keeps only the episodes with a matching title.
+	Match *regexp.Regexp
+
+	Sort    SortKey
+	Reverse bool
+}
+
+// Apply returns the episodes that pass the filter, in the requested order.
+// The episodes argument is left untouched.
+func (f *Filter) Apply(episodes []Episode) []Episode {
+	var result []Episode
+
+	for _, episode := range episodes {
+		if f.matches(&episode) {
+			result = append(result, episode)
+		}
+	}
+
+	if f.Last > 0 && len(result) > f.Last {
+		// feeds are in order of publication, oldest first, which is all
+		// there is to go by without dates
+		if allDated(result) {
+			sortEpisodes(result, SortDate)
+		}
+		result = result[len(result)-f.Last:]
+	}
+
+	sortEpisodes(result, f.Sort)
+
+	if f.Reverse {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+
+	return result
+}
+
+func allDated(episodes []Episode) bool {
+	for i := range episodes {
+		if episodes[i].PubDate().IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Filter) matches(episode *Episode) bool {
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		date := episode.PubDate()
+		if date.IsZero() {
+			return false
+		}
+		if !f.Since.IsZero() && date.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && date.After(f.Until) {
+			return false
+		}
+	}
+
+	if f.Downloaded != nil && episode.Downloaded != *f.Downloaded {
+		return false
+	}
+
+	if f.Match != nil && !f.Match.MatchString(episode.Title) {
+		return false
+	}
+
+	return true
+}
+
+func sortEpisodes(episodes []Episode, key SortKey) {
+	var less func(i, j int) bool
+
+	switch key {
+	case SortDate:
+		less = func(i, j int) bool {
+			return episodes[i].PubDate().Before(episodes[j].PubDate())
+		}
+	case SortTitle:
+		less = func(i, j int) bool {
+			return strings.ToLower(episodes[i].Title) < strings.ToLower(episodes[j].Title)
+		}
+	case SortSize:
+		less = func(i, j int) bool {
+			return episodes[i].Length < episodes[j].Length
+		}
+	default:
+		return
+	}
+
+	sort.SliceStable(episodes, less)
+}
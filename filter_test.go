@@ -0,0 +1,77 @@
+package pcd
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func filterEpisodes() []Episode {
+	return []Episode{
+		{ID: 1, Title: "Pilot", Date: "Mon, 02 Jan 2023 10:00:00 +0000", Length: 300},
+		{ID: 2, Title: "an interview", Date: "Wed, 01 Feb 2023 10:00:00 +0000", Length: 100, Downloaded: true},
+		{ID: 3, Title: "Bonus", Date: "Wed, 01 Mar 2023 10:00:00 +0000", Length: 200},
+		{ID: 4, Title: "Another interview", Date: "Sat, 01 Apr 2023 10:00:00 +0000", Length: 400, Downloaded: true},
+	}
+}
+
+func episodeIDs(episodes []Episode) []int {
+	var ids []int
+	for _, episode := range episodes {
+		ids = append(ids, episode.ID)
+	}
+	return ids
+}
+
+func TestFilterApply(t *testing.T) {
+	yes, no := true, false
+
+	table := []struct {
+		name   string
+		filter Filter
+		want   []int
+	}{
+		{"zero filter keeps everything", Filter{}, []int{1, 2, 3, 4}},
+		{"since", Filter{Since: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)}, []int{2, 3, 4}},
+		{"until", Filter{Until: time.Date(2023, 2, 1, 23, 0, 0, 0, time.UTC)}, []int{1, 2}},
+		{"last", Filter{Last: 2}, []int{3, 4}},
+		{"last larger than list", Filter{Last: 10}, []int{1, 2, 3, 4}},
+		{"downloaded", Filter{Downloaded: &yes}, []int{2, 4}},
+		{"not downloaded", Filter{Downloaded: &no}, []int{1, 3}},
+		{"match", Filter{Match: regexp.MustCompile(`(?i)interview`)}, []int{2, 4}},
+		{"reverse", Filter{Reverse: true}, []int{4, 3, 2, 1}},
+		{"sort by title", Filter{Sort: SortTitle}, []int{2, 4, 3, 1}},
+		{"sort by size", Filter{Sort: SortSize}, []int{2, 3, 1, 4}},
+		{"last applies before sorting", Filter{Last: 2, Sort: SortSize}, []int{3, 4}},
+		{"combined", Filter{Downloaded: &no, Sort: SortDate, Reverse: true}, []int{3, 1}},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			got := episodeIDs(e.filter.Apply(filterEpisodes()))
+			if !reflect.DeepEqual(got, e.want) {
+				t.Errorf("Expected %v, but got %v", e.want, got)
+			}
+		})
+	}
+}
+
+func TestFilterLastWithoutDates(t *testing.T) {
+	episodes := filterEpisodes()
+	episodes[3].Date = "someday"
+
+	got := episodeIDs((&Filter{Last: 2}).Apply(episodes))
+	if want := []int{3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, but got %v", want, got)
+	}
+}
+
+func TestParseSortKey(t *testing.T) {
+	if key, err := ParseSortKey("Title"); err != nil || key != SortTitle {
+		t.Errorf("Expected %q, but got %q (%#v)", SortTitle, key, err)
+	}
+	if _, err := ParseSortKey("length"); err != ErrInvalidSortKey {
+		t.Errorf("Expected %#v, but got %#v", ErrInvalidSortKey, err)
+	}
+}
@@ -4,9 +4,30 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 )
 
+// podcastIcon is the cover of the fixtures. It is served locally, so syncing
+// them doesn't fetch it from the internet.
+var podcastIcon = "http://www.example.com/podcast-icon.jpg"
+
+func TestMain(m *testing.M) {
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	}))
+
+	icon := images.URL + "/podcast-icon.jpg"
+	Podcastfeed = strings.Replace(Podcastfeed, podcastIcon, icon, -1)
+	invalidEpisodesFeed = strings.Replace(invalidEpisodesFeed, podcastIcon, icon, -1)
+	podcastIcon = icon
+
+	code := m.Run()
+	images.Close()
+	os.Exit(code)
+}
+
 var Podcastfeed = `<?xml version="1.0" encoding="UTF-8"?>
 <rss xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" version="2.0">
 <channel>
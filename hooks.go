@@ -0,0 +1,166 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Hook events
+const (
+	EventDownload       = "on_download"
+	EventSyncNewEpisode = "on_sync_new_episode"
+	EventError          = "on_error"
+)
+
+const defaultHookTimeout = time.Minute
+
+var (
+	ErrHookFailed  = errors.New("Hook exited with an error")
+	ErrHookTimeout = errors.New("Hook did not finish in time")
+)
+
+// Hooks are shell commands that run when something happens to an episode.
+// The event is described in PCD_* environment variables and as JSON on the
+// command's stdin.
+type Hooks struct {
+	OnDownload       string `mapstructure:"on_download"`
+	OnSyncNewEpisode string `mapstructure:"on_sync_new_episode"`
+	OnError          string `mapstructure:"on_error"`
+
+	// Timeout after which a hook is killed, one minute by default.
+	Timeout time.Duration
+}
+
+// HookEvent is the information passed to a hook.
+type HookEvent struct {
+	Event     string   `json:"event"`
+	PodcastID int      `json:"podcast_id"`
+	Podcast   string   `json:"podcast"`
+	Episode   *Episode `json:"episode,omitempty"`
+	// File is the path of the downloaded episode.
+	File  string `json:"file,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Merge returns the hooks in h, replaced by the ones that are set in
+// override.
+func (h Hooks) Merge(override Hooks) Hooks {
+	if override.OnDownload != "" {
+		h.OnDownload = override.OnDownload
+	}
+	if override.OnSyncNewEpisode != "" {
+		h.OnSyncNewEpisode = override.OnSyncNewEpisode
+	}
+	if override.OnError != "" {
+		h.OnError = override.OnError
+	}
+	if override.Timeout != 0 {
+		h.Timeout = override.Timeout
+	}
+	return h
+}
+
+func (h *Hooks) command(event string) string {
+	switch event {
+	case EventDownload:
+		return h.OnDownload
+	case EventSyncNewEpisode:
+		return h.OnSyncNewEpisode
+	case EventError:
+		return h.OnError
+	default:
+		return ""
+	}
+}
+
+// Run runs the hook configured for the event, if any. The hook's output is
+// passed on to stderr.
+func (h *Hooks) Run(event HookEvent) error {
+	command := h.command(event.Event)
+	if command == "" {
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Print(err)
+		return ErrEncodeError
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), event.environ()...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	killProcessGroup(cmd)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Printf("Hook %s (%q) was killed after %s", event.Event, command, timeout)
+			return ErrHookTimeout
+		}
+		log.Printf("Hook %s (%q) failed: %v", event.Event, command, err)
+		return ErrHookFailed
+	}
+
+	return nil
+}
+
+func (e *HookEvent) environ() []string {
+	env := []string{
+		"PCD_EVENT=" + e.Event,
+		"PCD_PODCAST=" + e.Podcast,
+		"PCD_PODCAST_ID=" + strconv.Itoa(e.PodcastID),
+		"PCD_FILE=" + e.File,
+		"PCD_ERROR=" + e.Error,
+	}
+
+	if e.Episode != nil {
+		env = append(env,
+			fmt.Sprintf("PCD_EPISODE_ID=%d", e.Episode.ID),
+			"PCD_EPISODE_TITLE="+e.Episode.Title,
+			"PCD_EPISODE_DATE="+e.Episode.Date,
+			"PCD_EPISODE_URL="+e.Episode.URL,
+			"PCD_EPISODE_GUID="+e.Episode.GUID,
+		)
+	}
+
+	return env
+}
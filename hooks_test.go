@@ -0,0 +1,83 @@
+package pcd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHooksRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are tested with a POSIX shell")
+	}
+
+	path := randomPath(t)
+	stdin := filepath.Join(path, "stdin")
+	env := filepath.Join(path, "env")
+
+	hooks := Hooks{
+		OnDownload: "cat > " + stdin + "; echo \"$PCD_EVENT|$PCD_PODCAST|$PCD_EPISODE_TITLE|$PCD_FILE\" > " + env,
+		OnError:    "exit 3",
+	}
+	event := HookEvent{
+		Event:   EventDownload,
+		Podcast: "test",
+		Episode: &Episode{ID: 1, Title: "foo"},
+		File:    "/tmp/foo.mp3",
+	}
+
+	if err := hooks.Run(event); err != nil {
+		t.Fatalf("Expected hook to succeed, but got: %#v", err)
+	}
+
+	content, err := os.ReadFile(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(content)); got != "on_download|test|foo|/tmp/foo.mp3" {
+		t.Errorf("Expected environment to describe the event, but got %q", got)
+	}
+
+	content, err = os.ReadFile(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got HookEvent
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatalf("Expected JSON on stdin, but got %q", content)
+	}
+	if got.Episode == nil || got.Episode.Title != "foo" || got.File != event.File {
+		t.Errorf("Expected stdin to describe the event, but got %#v", got)
+	}
+
+	table := []struct {
+		name  string
+		hooks Hooks
+		event string
+		want  error
+	}{
+		{"no hook configured", hooks, EventSyncNewEpisode, nil},
+		{"failing hook", hooks, EventError, ErrHookFailed},
+		{"slow hook", Hooks{OnError: "sleep 5", Timeout: 50 * time.Millisecond}, EventError, ErrHookTimeout},
+	}
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			if err := e.hooks.Run(HookEvent{Event: e.event}); err != e.want {
+				t.Errorf("Expected %#v, but got %#v", e.want, err)
+			}
+		})
+	}
+}
+
+func TestHooksMerge(t *testing.T) {
+	global := Hooks{OnDownload: "global", OnError: "global", Timeout: time.Second}
+	merged := global.Merge(Hooks{OnError: "podcast"})
+
+	if merged.OnDownload != "global" || merged.OnError != "podcast" || merged.Timeout != time.Second {
+		t.Errorf("Expected podcast hooks to override global ones, but got %#v", merged)
+	}
+}
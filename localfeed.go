@@ -0,0 +1,189 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The local feed is a plain RSS 2.0 feed, with the itunes namespace for the
+// cover, listing the episodes downloaded into the podcast's path.
+type localRSS struct {
+	XMLName xml.Name     `xml:"rss"`
+	Version string       `xml:"version,attr"`
+	ITunes  string       `xml:"xmlns:itunes,attr"`
+	Channel localChannel `xml:"channel"`
+}
+
+type localChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Image         *localImage `xml:"itunes:image"`
+	Items         []localItem `xml:"item"`
+}
+
+type localImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type localItem struct {
+	Title       string         `xml:"title"`
+	Description string         `xml:"description,omitempty"`
+	PubDate     string         `xml:"pubDate,omitempty"`
+	GUID        localGUID      `xml:"guid"`
+	Enclosure   localEnclosure `xml:"enclosure"`
+	Image       *localImage    `xml:"itunes:image"`
+}
+
+type localGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	GUID        string `xml:",chardata"`
+}
+
+type localEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+// WriteLocalFeed writes an RSS feed of the episodes that were downloaded into
+// the podcast's path, newest first. The enclosures point at baseURL, the url
+// the podcast's path is served under. The episodes must be loaded.
+func (p *Podcast) WriteLocalFeed(w io.Writer, baseURL string) error {
+	state, err := LoadState(p.Path)
+	if err != nil {
+		return err
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	feed := localRSS{
+		Version: "2.0",
+		ITunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: localChannel{
+			Title:         p.Name,
+			Link:          baseURL + "/",
+			Description:   "Episodes of " + p.Name + " downloaded by pcd",
+			LastBuildDate: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+	if cover := p.Artwork.coverFilename(); cover != "" && state.findImage(cover) != nil {
+		feed.Channel.Image = &localImage{Href: fileURL(baseURL, cover)}
+	}
+
+	for i := len(p.Episodes) - 1; i >= 0; i-- {
+		episode := &p.Episodes[i]
+		download := state.Find(episode)
+		if download == nil || download.Pruned {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(p.Path, download.Filename))
+		if err != nil {
+			// removed behind our back
+			continue
+		}
+
+		item := localItem{
+			Title:       episode.Title,
+			Description: episode.Description,
+			PubDate:     episode.Date,
+			GUID:        localGUID{GUID: episode.Key()},
+			Enclosure: localEnclosure{
+				URL:    fileURL(baseURL, download.Filename),
+				Type:   mediaType(download.Filename),
+				Length: info.Size(),
+			},
+		}
+		if download.Image != "" {
+			item.Image = &localImage{Href: fileURL(baseURL, download.Image)}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		log.Printf("Could not write feed: %#v", err)
+		return ErrFilesystemError
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		log.Printf("Could not encode feed: %#v", err)
+		return ErrEncodeError
+	}
+
+	return nil
+}
+
+// ServedFile returns the path of a file pcd wrote into the podcast's path,
+// an episode or one of its sidecars, or the cover. Any other name, like the
+// cache and state files, gives an empty string, so they are never served.
+func (p *Podcast) ServedFile(name string) string {
+	if name == "" || filepath.Base(name) != name {
+		return ""
+	}
+
+	state, err := LoadState(p.Path)
+	if err != nil {
+		return ""
+	}
+
+	for _, download := range state.Downloads {
+		if download.Pruned {
+			continue
+		}
+		for _, file := range download.files() {
+			if file == name {
+				return filepath.Join(p.Path, name)
+			}
+		}
+	}
+	for _, image := range state.Images {
+		if image.Filename == name {
+			return filepath.Join(p.Path, name)
+		}
+	}
+
+	return ""
+}
+
+func fileURL(baseURL, filename string) string {
+	return baseURL + "/" + url.PathEscape(filename)
+}
+
+// mediaType guesses the MIME type of an episode file from its extension.
+func mediaType(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".mp3":
+		return "audio/mpeg"
+	case ".m4a", ".m4b":
+		return "audio/mp4"
+	}
+
+	if typ := mime.TypeByExtension(filepath.Ext(filename)); typ != "" {
+		return typ
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,93 @@
+package pcd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestWriteLocalFeed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("audio of " + r.URL.Path))
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Path: randomPath(t)}
+	defer os.RemoveAll(podcast.Path)
+	podcast.Episodes = []Episode{
+		{ID: 1, Title: "Old episode", URL: ts.URL + "/old episode.mp3", GUID: "old"},
+		{ID: 2, Title: "Not downloaded", URL: ts.URL + "/skipped.mp3", GUID: "skipped"},
+		{ID: 3, Title: "New episode", URL: ts.URL + "/new.m4a", GUID: "new", Date: "Wed, 21 Dec 2016 16:01:07 +0000"},
+	}
+	for _, i := range []int{0, 2} {
+		if err := podcast.Episodes[i].Download(podcast.Path, ioutil.Discard, ""); err != nil {
+			t.Fatalf("Expected to be able to download, but got: %#v", err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := podcast.WriteLocalFeed(&b, "http://nas.local/test/"); err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+
+	var feed localRSS
+	if err := xml.Unmarshal(b.Bytes(), &feed); err != nil {
+		t.Fatalf("Expected a valid feed, but got: %#v\n%s", err, b.String())
+	}
+
+	expected := []localItem{
+		{
+			Title:     "New episode",
+			PubDate:   "Wed, 21 Dec 2016 16:01:07 +0000",
+			GUID:      localGUID{GUID: "new"},
+			Enclosure: localEnclosure{URL: "http://nas.local/test/new.m4a", Type: "audio/mp4", Length: int64(len("audio of /new.m4a"))},
+		},
+		{
+			Title:     "Old episode",
+			GUID:      localGUID{GUID: "old"},
+			Enclosure: localEnclosure{URL: "http://nas.local/test/old%20episode.mp3", Type: "audio/mpeg", Length: int64(len("audio of /old episode.mp3"))},
+		},
+	}
+	if len(feed.Channel.Items) != len(expected) {
+		t.Fatalf("Expected %d items, but got: %#v", len(expected), feed.Channel.Items)
+	}
+	for i := range expected {
+		if feed.Channel.Items[i] != expected[i] {
+			t.Errorf("Expected %#v, but got: %#v", expected[i], feed.Channel.Items[i])
+		}
+	}
+}
+
+func TestServedFile(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("audio"))
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Path: randomPath(t)}
+	defer os.RemoveAll(podcast.Path)
+	episode := &Episode{URL: ts.URL + "/episode.mp3"}
+	if err := episode.Download(podcast.Path, ioutil.Discard, ""); err != nil {
+		t.Fatalf("Expected to be able to download, but got: %#v", err)
+	}
+
+	table := []struct {
+		name     string
+		expected bool
+	}{
+		{"episode.mp3", true},
+		{".state", false},
+		{"../episode.mp3", false},
+		{"other.mp3", false},
+	}
+
+	for _, e := range table {
+		if got := podcast.ServedFile(e.name) != ""; got != e.expected {
+			t.Errorf("Expected %s to be served: %v, but got: %v", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kvannotten/pcd/rss"
+)
+
+// isFileURL tells whether the feed is a file:// url of a feed on disk.
+func isFileURL(feed string) bool {
+	return strings.HasPrefix(strings.ToLower(feed), "file:")
+}
+
+// readFeedFile parses the feed file at the file:// url.
+func readFeedFile(fileURL string) (*rss.PodcastFeed, error) {
+	path, err := FeedFilePath(fileURL)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrCouldNotSync
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrFeedNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrFilesystemError
+	}
+	defer f.Close()
+
+	feed, err := rss.ParseWith(f, rss.Options{Recover: true})
+	if err != nil {
+		log.Print(err)
+		return nil, ErrParserIssue
+	}
+	return feed, nil
+}
+
+// FeedFilePath returns the path of the file:// url of a feed on disk. Only
+// local files are supported, so the host must be empty or localhost.
+func FeedFilePath(fileURL string) (string, error) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", &url.Error{Op: "open", URL: fileURL, Err: os.ErrInvalid}
+	}
+
+	path := u.Path
+	if u.Opaque != "" {
+		// file:feed.xml, relative to the working directory
+		path = u.Opaque
+	}
+	// file:///C:/feeds/feed.xml on windows
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path), nil
+}
@@ -0,0 +1,78 @@
+package pcd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSyncFileURL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "feed.xml")
+	if err := os.WriteFile(path, []byte(Podcastfeed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	podcast := &Podcast{Name: "test", Feed: "file://" + filepath.ToSlash(path), Path: randomPath(t)}
+	if runtime.GOOS == "windows" {
+		podcast.Feed = "file:///" + filepath.ToSlash(path)
+	}
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if len(podcast.Episodes) != 1 || podcast.Episodes[0].Title != "Title of Podcast Episode" {
+		t.Errorf("Expected the episode of the feed, but got: %#v", podcast.Episodes)
+	}
+	if err := podcast.Load(); err != nil {
+		t.Errorf("Expected the episodes to be cached, but got: %#v", err)
+	}
+
+	podcast.Feed = "file://" + filepath.ToSlash(filepath.Join(dir, "missing.xml"))
+	if _, err := podcast.Sync(); err != ErrFeedNotFound {
+		t.Errorf("Expected %#v, but got: %#v", ErrFeedNotFound, err)
+	}
+}
+
+func TestSyncFrom(t *testing.T) {
+	podcast := &Podcast{Name: "test", Feed: "http://example.com/feed.xml", Path: randomPath(t)}
+	result, err := podcast.SyncFrom(strings.NewReader(Podcastfeed))
+	if err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if len(result.Added) != 1 {
+		t.Errorf("Expected 1 added episode, but got: %#v", result.Added)
+	}
+	if _, err := os.Stat(filepath.Join(podcast.Path, "folder.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected no cover to be fetched, but got: %#v", err)
+	}
+
+	if _, err := podcast.SyncFrom(strings.NewReader("not a feed")); err != ErrParserIssue {
+		t.Errorf("Expected %#v, but got: %#v", ErrParserIssue, err)
+	}
+}
+
+func TestFeedFilePath(t *testing.T) {
+	table := []struct {
+		url  string
+		path string
+		err  bool
+	}{
+		{"file:///srv/feeds/feed.xml", "/srv/feeds/feed.xml", false},
+		{"file://localhost/srv/feeds/feed.xml", "/srv/feeds/feed.xml", false},
+		{"file:feed.xml", "feed.xml", false},
+		{"file:///C:/feeds/feed.xml", "C:/feeds/feed.xml", false},
+		{"file://nas/feeds/feed.xml", "", true},
+	}
+
+	for _, e := range table {
+		path, err := FeedFilePath(e.url)
+		if (err != nil) != e.err {
+			t.Errorf("Expected error %v for %s, but got: %#v", e.err, e.url, err)
+		}
+		if !e.err && filepath.ToSlash(path) != e.path {
+			t.Errorf("Expected %#v, but got: %#v", e.path, path)
+		}
+	}
+}
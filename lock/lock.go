@@ -0,0 +1,124 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package lock keeps several pcd processes from working on the same files at
+// once, with advisory locks on lock files. The locks go away with the
+// process holding them, so a crashed pcd never leaves a stale lock behind.
+package lock
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrCouldNotLock = errors.New("Could not lock file")
+
+// LockedError is returned when another process holds the lock.
+type LockedError struct {
+	Path string
+	PID  int
+	// Holder describes what the other process is doing, like "syncing
+	// biggest_problem".
+	Holder string
+}
+
+func (e *LockedError) Error() string {
+	switch {
+	case e.PID > 0 && e.Holder != "":
+		return fmt.Sprintf("another pcd (process %d) is %s", e.PID, e.Holder)
+	case e.PID > 0:
+		return fmt.Sprintf("another pcd (process %d) holds %s", e.PID, e.Path)
+	default:
+		return fmt.Sprintf("another pcd holds %s", e.Path)
+	}
+}
+
+// Lock is a held lock.
+type Lock struct {
+	f *os.File
+}
+
+// TryAcquire takes the lock on the file at path, creating it when needed. It
+// returns a *LockedError when another process holds it. holder is recorded
+// in the file for the error of the processes that don't get the lock.
+func TryAcquire(path, holder string) (*Lock, error) {
+	return acquire(path, holder, false)
+}
+
+// Acquire takes the lock on the file at path, waiting for other processes
+// to release it.
+func Acquire(path, holder string) (*Lock, error) {
+	return acquire(path, holder, true)
+}
+
+func acquire(path, holder string, wait bool) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Printf("Could not open lock file: %#v", err)
+		return nil, ErrCouldNotLock
+	}
+
+	locked, err := lockFile(f, wait)
+	if err != nil {
+		f.Close()
+		log.Printf("Could not lock file: %#v", err)
+		return nil, ErrCouldNotLock
+	}
+	if !locked {
+		lerr := readHolder(path)
+		f.Close()
+		return nil, lerr
+	}
+
+	// the lock is on the file, not its contents, so rewriting them is fine
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), holder)), 0)
+	}
+
+	return &Lock{f: f}, nil
+}
+
+// Release releases the lock. The lock file is left in place, removing it
+// would let two processes lock different files under the same name.
+func (l *Lock) Release() error {
+	defer l.f.Close()
+
+	l.f.Truncate(0)
+	if err := unlockFile(l.f); err != nil {
+		log.Printf("Could not unlock file: %#v", err)
+		return ErrCouldNotLock
+	}
+	return nil
+}
+
+func readHolder(path string) *LockedError {
+	lerr := &LockedError{Path: path}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return lerr
+	}
+	lines := strings.SplitN(string(content), "\n", 3)
+	lerr.PID, _ = strconv.Atoi(strings.TrimSpace(lines[0]))
+	if len(lines) > 1 {
+		lerr.Holder = strings.TrimSpace(lines[1])
+	}
+	return lerr
+}
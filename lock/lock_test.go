@@ -0,0 +1,67 @@
+package lock
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTryAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+
+	l, err := TryAcquire(path, "syncing biggest_problem")
+	if err != nil {
+		t.Fatalf("Expected to get the lock, but got: %#v", err)
+	}
+
+	_, err = TryAcquire(path, "downloading")
+	lerr, ok := err.(*LockedError)
+	if !ok || lerr.PID != os.Getpid() || lerr.Holder != "syncing biggest_problem" {
+		t.Fatalf("Expected the lock to be held by us, but got: %#v", err)
+	}
+	if expected := "another pcd (process " + strconv.Itoa(os.Getpid()) + ") is syncing biggest_problem"; lerr.Error() != expected {
+		t.Errorf("Expected %#v, but got: %#v", expected, lerr.Error())
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Expected to release the lock, but got: %#v", err)
+	}
+	if l, err = TryAcquire(path, "downloading"); err != nil {
+		t.Fatalf("Expected to get the released lock, but got: %#v", err)
+	}
+	l.Release()
+}
+
+func TestAcquireWaits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+
+	l, err := TryAcquire(path, "syncing")
+	if err != nil {
+		t.Fatalf("Expected to get the lock, but got: %#v", err)
+	}
+
+	acquired := make(chan *Lock)
+	go func() {
+		l, err := Acquire(path, "waiting")
+		if err != nil {
+			t.Errorf("Expected to get the lock after waiting, but got: %#v", err)
+		}
+		acquired <- l
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Expected Acquire to wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.Release()
+	select {
+	case l := <-acquired:
+		l.Release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Acquire to get the released lock")
+	}
+}
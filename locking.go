@@ -0,0 +1,44 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/kvannotten/pcd/lock"
+)
+
+const lockFile = ".lock"
+
+// Lock takes the lock on the podcast's path, so two pcd processes never
+// sync, download or prune into it at the same time. action describes what
+// the lock is taken for, like "syncing", and shows up in the *lock.LockedError
+// other processes get. With wait, Lock waits for the other process instead.
+func (p *Podcast) Lock(action string, wait bool) (*lock.Lock, error) {
+	if err := os.MkdirAll(p.Path, os.ModePerm); err != nil {
+		log.Print(err)
+		return nil, ErrFilesystemError
+	}
+
+	path := filepath.Join(p.Path, lockFile)
+	holder := action + " " + p.Name
+	if wait {
+		return lock.Acquire(path, holder)
+	}
+	return lock.TryAcquire(path, holder)
+}
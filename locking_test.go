@@ -0,0 +1,35 @@
+package pcd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kvannotten/pcd/lock"
+)
+
+func TestPodcastLock(t *testing.T) {
+	podcast := &Podcast{Name: "locked", Path: t.TempDir()}
+
+	l, err := podcast.Lock("syncing", false)
+	if err != nil {
+		t.Fatalf("Could not lock podcast: %#v", err)
+	}
+
+	_, err = podcast.Lock("pruning", false)
+	lerr, ok := err.(*lock.LockedError)
+	if !ok {
+		t.Fatalf("Expected %#v, but got: %#v", &lock.LockedError{}, err)
+	}
+	if !strings.Contains(lerr.Error(), "is syncing locked") {
+		t.Errorf("Expected the holder in the error, but got: %#v", lerr.Error())
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Could not release lock: %#v", err)
+	}
+	l, err = podcast.Lock("pruning", false)
+	if err != nil {
+		t.Fatalf("Expected %#v, but got: %#v", nil, err)
+	}
+	l.Release()
+}
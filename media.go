@@ -0,0 +1,173 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrNoMedia = errors.New("Episode has no media to download")
+
+// Media is a file the feed offers for an episode, from an enclosure or a
+// media:content.
+type Media struct {
+	URL    string `json:"url"`
+	Type   string `json:"type,omitempty"`
+	Length int64  `json:"length,omitempty"`
+	// Bitrate is in kbit/s, estimated from the length and duration when the
+	// feed doesn't give it. Zero when unknown.
+	Bitrate int `json:"bitrate,omitempty"`
+	// Default is set for the media:content the feed marks as the default.
+	Default bool `json:"default,omitempty"`
+}
+
+// chooseMedia picks the file to download out of the media of an episode.
+// The podcast's preferred types narrow them down first, its preferred
+// bitrate then picks the closest one. Without preferences it is the media
+// marked as the default, or else the first one, which is the enclosure.
+func (p *Podcast) chooseMedia(media []Media) Media {
+	candidates := media
+	for _, pattern := range p.PreferredTypes {
+		var matching []Media
+		for _, m := range media {
+			if mediaTypeMatches(pattern, m.Type) {
+				matching = append(matching, m)
+			}
+		}
+		if len(matching) > 0 {
+			candidates = matching
+			break
+		}
+	}
+
+	if p.PreferredBitrate > 0 {
+		best := -1
+		for i, m := range candidates {
+			if m.Bitrate == 0 {
+				continue
+			}
+			if best < 0 || abs(m.Bitrate-p.PreferredBitrate) < abs(candidates[best].Bitrate-p.PreferredBitrate) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			return candidates[best]
+		}
+	}
+
+	for _, m := range candidates {
+		if m.Default {
+			return m
+		}
+	}
+	return candidates[0]
+}
+
+// allowedMedia returns the media of the podcast's media types, or all of
+// them when it doesn't restrict them.
+func (p *Podcast) allowedMedia(media []Media) []Media {
+	if len(p.MediaTypes) == 0 {
+		return media
+	}
+
+	var allowed []Media
+	for _, m := range media {
+		for _, pattern := range p.MediaTypes {
+			if mediaTypeMatches(pattern, m.Type) {
+				allowed = append(allowed, m)
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+// allowedEpisodes leaves out the episodes without media of the podcast's
+// media types, for the cached episodes of a sync from before the types were
+// restricted.
+func (p *Podcast) allowedEpisodes(episodes []Episode) []Episode {
+	if len(p.MediaTypes) == 0 {
+		return episodes
+	}
+
+	var allowed []Episode
+	for _, episode := range episodes {
+		media := episode.Media
+		if len(media) == 0 {
+			media = []Media{{URL: episode.URL, Type: episode.Type}}
+		}
+		if len(p.allowedMedia(media)) > 0 {
+			allowed = append(allowed, episode)
+		}
+	}
+	return allowed
+}
+
+// urlMediaType guesses the MIME type of the media at the url from its
+// extension. It returns an empty string when there is no telling.
+func urlMediaType(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.Contains(u.Path, ".") {
+		return ""
+	}
+	if t := mediaType(u.Path); t != "application/octet-stream" {
+		return t
+	}
+	return ""
+}
+
+// FormatSize formats a size in bytes for humans, like "12.3 MiB".
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// mediaTypeMatches tells whether the MIME type matches the pattern, like
+// "audio/mpeg" or "audio/*". Parameters of the type are ignored.
+func mediaTypeMatches(pattern, mediaType string) bool {
+	if t, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = t
+	}
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+		return strings.HasPrefix(mediaType, prefix)
+	}
+	return mediaType == pattern
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
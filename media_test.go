@@ -0,0 +1,156 @@
+package pcd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kvannotten/pcd/rss"
+)
+
+func TestChooseMedia(t *testing.T) {
+	media := []Media{
+		{URL: "episode.mp3", Type: "audio/mpeg", Bitrate: 128},
+		{URL: "episode-low.mp3", Type: "audio/mpeg", Bitrate: 48},
+		{URL: "episode.m4a", Type: "audio/mp4; codecs=mp4a.40.2", Bitrate: 96},
+		{URL: "episode.mp4", Type: "video/mp4", Default: true},
+	}
+
+	table := []struct {
+		name    string
+		podcast Podcast
+		want    string
+	}{
+		{"default", Podcast{}, "episode.mp4"},
+		{"type", Podcast{PreferredTypes: []string{"audio/mp4"}}, "episode.m4a"},
+		{"first type present", Podcast{PreferredTypes: []string{"audio/ogg", "AUDIO/*"}}, "episode.mp3"},
+		{"no type present", Podcast{PreferredTypes: []string{"audio/ogg"}}, "episode.mp4"},
+		{"bitrate", Podcast{PreferredBitrate: 64}, "episode-low.mp3"},
+		{"type and bitrate", Podcast{PreferredTypes: []string{"audio/mpeg"}, PreferredBitrate: 100}, "episode.mp3"},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			if got := e.podcast.chooseMedia(media); got.URL != e.want {
+				t.Errorf("Expected %#v, but got: %#v", e.want, got.URL)
+			}
+		})
+	}
+}
+
+func TestEpisodesWithoutMedia(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<item><title>Announcement</title></item>
+<item><title>Episode</title><enclosure url="http://example.com/episode.mp3" type="audio/mpeg"/></item>
+</channel>
+</rss>`
+
+	episodes, err := parseEpisodes(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+	if len(episodes) != 1 || episodes[0].Title != "Episode" || episodes[0].ID != 1 {
+		t.Errorf("Expected only the episode with media, but got: %#v", episodes)
+	}
+
+	episode := Episode{Title: "Announcement"}
+	if err := episode.Download(randomPath(t), nil, ""); err != ErrNoMedia {
+		t.Errorf("Expected %#v, but got: %#v", ErrNoMedia, err)
+	}
+}
+
+func TestMediaTypes(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<item><title>Audio</title><enclosure url="http://example.com/audio.mp3" length="2097152"/></item>
+<item><title>Video</title><enclosure url="http://example.com/video.mp4" type="video/mp4"/></item>
+<item><title>Both</title><enclosure url="http://example.com/both.mp4" type="video/mp4"/><enclosure url="http://example.com/both.m4a" type="audio/mp4"/></item>
+</channel>
+</rss>`
+
+	table := []struct {
+		name       string
+		mediaTypes []string
+		want       []string
+	}{
+		{"everything", nil, []string{"http://example.com/audio.mp3", "http://example.com/video.mp4", "http://example.com/both.mp4"}},
+		{"audio", []string{"audio/*"}, []string{"http://example.com/audio.mp3", "http://example.com/both.m4a"}},
+		{"video", []string{"video/mp4"}, []string{"http://example.com/video.mp4", "http://example.com/both.mp4"}},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			parsed, err := rss.Parse(strings.NewReader(feed))
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %#v", err)
+			}
+			podcast := Podcast{MediaTypes: e.mediaTypes}
+			episodes, _ := podcast.feedEpisodes(parsed)
+
+			var got []string
+			for _, episode := range episodes {
+				got = append(got, episode.URL)
+			}
+			if strings.Join(got, " ") != strings.Join(e.want, " ") {
+				t.Errorf("Expected %#v, but got: %#v", e.want, got)
+			}
+		})
+	}
+}
+
+func TestSyncLeavesOutMediaTypes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<item><title>Audio</title><guid>audio</guid><enclosure url="http://example.com/audio.mp3" type="audio/mpeg"/></item>
+<item><title>Video</title><guid>video</guid><enclosure url="http://example.com/video.mp4" type="video/mp4"/></item>
+</channel>
+</rss>`))
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Feed: ts.URL, Path: randomPath(t)}
+	defer os.RemoveAll(podcast.Path)
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if len(podcast.Episodes) != 2 {
+		t.Fatalf("Expected both episodes, but got: %#v", podcast.Episodes)
+	}
+
+	// the video episode isn't archived, it is gone
+	podcast.MediaTypes = []string{"audio/*"}
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	if len(podcast.Episodes) != 1 || podcast.Episodes[0].Title != "Audio" || podcast.Episodes[0].Archived {
+		t.Errorf("Expected only the audio episode, but got: %#v", podcast.Episodes)
+	}
+	if err := podcast.Load(); err != nil {
+		t.Fatalf("Expected the episodes to be cached, but got: %#v", err)
+	}
+	if len(podcast.Episodes) != 1 {
+		t.Errorf("Expected only the audio episode to be cached, but got: %#v", podcast.Episodes)
+	}
+}
+
+func TestListShowsMedia(t *testing.T) {
+	podcast := Podcast{Name: "test", Episodes: []Episode{
+		{ID: 1, Title: "Audio", Type: "audio/mpeg", Length: 2097152},
+		{ID: 2, Title: "Unknown"},
+	}}
+
+	list := podcast.String()
+	if !strings.Contains(list, "audio/mpeg      2.0 MiB") {
+		t.Errorf("Expected the type and length of the episode, but got:\n%s", list)
+	}
+	if !strings.Contains(list, "-                     -") {
+		t.Errorf("Expected placeholders for the unknown type and length, but got:\n%s", list)
+	}
+}
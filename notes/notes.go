@@ -0,0 +1,447 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package notes turns the HTML of show notes into sanitized HTML, Markdown
+// or plain text. Only a handful of formatting tags and http(s) and mailto
+// links survive, everything else is dropped.
+package notes
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	textToken tokenKind = iota
+	startToken
+	endToken
+)
+
+type token struct {
+	kind tokenKind
+	// tag is the lower case tag name of start and end tokens
+	tag  string
+	text string
+	href string
+}
+
+// allowed are the tags that are kept, true for block level tags.
+var allowed = map[string]bool{
+	"p": true, "div": true, "br": true, "blockquote": true, "pre": true,
+	"ul": true, "ol": true, "li": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"a": false, "b": false, "strong": false, "i": false, "em": false, "code": false,
+}
+
+// dropped are the tags whose content is removed along with them.
+var dropped = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true,
+	"noscript": true, "template": true, "head": true, "title": true,
+}
+
+var (
+	paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+	tagName        = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9]*)`)
+	hrefRe         = regexp.MustCompile(`(?is)\shref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// tokenize splits s into text and the allowed tags. Notes without any
+// markup are plain text, their line breaks are kept.
+func tokenize(s string) []token {
+	var tokens []token
+	markup := false
+
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			i = len(s)
+		}
+		if i > 0 {
+			tokens = append(tokens, token{kind: textToken, text: html.UnescapeString(s[:i])})
+			s = s[i:]
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			s = skipPast(s, "-->")
+			continue
+		case strings.HasPrefix(s, "<!"), strings.HasPrefix(s, "<?"):
+			s = skipPast(s, ">")
+			continue
+		}
+
+		m := tagName.FindStringSubmatch(s)
+		if m == nil {
+			// a lone <, as in "1 < 2"
+			tokens = append(tokens, token{kind: textToken, text: "<"})
+			s = s[1:]
+			continue
+		}
+		markup = true
+
+		end := tagEnd(s)
+		raw := s[:end]
+		s = s[end:]
+
+		name := strings.ToLower(m[1])
+		closing := strings.HasPrefix(raw, "</")
+		if dropped[name] && !closing {
+			s = skipPastFold(s, "</"+name)
+			s = skipPast(s, ">")
+			continue
+		}
+		if _, ok := allowed[name]; !ok {
+			continue
+		}
+
+		if closing {
+			tokens = append(tokens, token{kind: endToken, tag: name})
+			continue
+		}
+		t := token{kind: startToken, tag: name}
+		if name == "a" {
+			t.href = safeURL(attribute(hrefRe, raw))
+		}
+		tokens = append(tokens, t)
+	}
+
+	if !markup {
+		return plainText(tokens)
+	}
+	return tokens
+}
+
+// plainText turns the line breaks of text without markup into tags.
+func plainText(tokens []token) []token {
+	var text strings.Builder
+	for _, t := range tokens {
+		text.WriteString(t.text)
+	}
+
+	var result []token
+	for _, paragraph := range paragraphBreak.Split(strings.TrimSpace(text.String()), -1) {
+		result = append(result, token{kind: startToken, tag: "p"})
+		for i, line := range strings.Split(paragraph, "\n") {
+			if i > 0 {
+				result = append(result, token{kind: startToken, tag: "br"})
+			}
+			result = append(result, token{kind: textToken, text: line})
+		}
+		result = append(result, token{kind: endToken, tag: "p"})
+	}
+	return result
+}
+
+// tagEnd returns the index after the > closing the tag at the start of s,
+// skipping over quoted attribute values.
+func tagEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == '>':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func skipPast(s, marker string) string {
+	if i := strings.Index(s, marker); i >= 0 {
+		return s[i+len(marker):]
+	}
+	return ""
+}
+
+func skipPastFold(s, marker string) string {
+	if i := strings.Index(strings.ToLower(s), marker); i >= 0 {
+		return s[i:]
+	}
+	return ""
+}
+
+func attribute(re *regexp.Regexp, tag string) string {
+	m := re.FindStringSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	return html.UnescapeString(m[1] + m[2] + m[3])
+}
+
+// safeURL returns u when it is an http(s) or mailto link. Anything else,
+// javascript: urls in particular, is dropped.
+func safeURL(u string) string {
+	u = strings.TrimSpace(u)
+	lower := strings.ToLower(u)
+	for _, scheme := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(lower, scheme) {
+			return u
+		}
+	}
+	return ""
+}
+
+// HTML returns the notes with only the allowed tags and links, and every
+// tag that was opened closed.
+func HTML(s string) string {
+	var b bytes.Buffer
+	var open []string
+
+	for _, t := range tokenize(s) {
+		switch t.kind {
+		case textToken:
+			b.WriteString(html.EscapeString(t.text))
+		case startToken:
+			switch {
+			case t.tag == "br":
+				b.WriteString("<br>")
+			case t.tag == "a":
+				// links that were dropped keep their text
+				if t.href != "" {
+					b.WriteString(`<a href="` + html.EscapeString(t.href) + `">`)
+					open = append(open, t.tag)
+				}
+			default:
+				b.WriteString("<" + t.tag + ">")
+				open = append(open, t.tag)
+			}
+		case endToken:
+			// close whatever was left open inside, ignore stray end tags
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != t.tag {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// Text returns the notes as plain text, with links written out after their
+// text.
+func Text(s string) string {
+	return render(s, false)
+}
+
+// Markdown returns the notes as Markdown.
+func Markdown(s string) string {
+	return render(s, true)
+}
+
+type link struct {
+	start int
+	href  string
+}
+
+func render(s string, markdown bool) string {
+	w := &writer{}
+	var links []link
+	var lists []int // item counters of the open lists, -1 for unordered
+	pre := 0
+
+	for _, t := range tokenize(s) {
+		switch t.kind {
+		case textToken:
+			if pre > 0 {
+				w.raw(t.text)
+			} else if markdown {
+				w.text(escapeMarkdown(t.text))
+			} else {
+				w.text(t.text)
+			}
+		case startToken:
+			switch t.tag {
+			case "br":
+				w.newline()
+			case "p", "div", "blockquote":
+				w.paragraph()
+				if markdown && t.tag == "blockquote" {
+					w.raw("> ")
+				}
+			case "pre":
+				w.paragraph()
+				if markdown {
+					w.raw("```\n")
+				}
+				pre++
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				w.paragraph()
+				if markdown {
+					w.raw(strings.Repeat("#", int(t.tag[1]-'0')) + " ")
+				}
+			case "ul":
+				w.newline()
+				lists = append(lists, -1)
+			case "ol":
+				w.newline()
+				lists = append(lists, 0)
+			case "li":
+				w.newline()
+				w.raw(strings.Repeat("  ", max(len(lists)-1, 0)))
+				if n := len(lists); n > 0 && lists[n-1] >= 0 {
+					lists[n-1]++
+					w.raw(strconv.Itoa(lists[n-1]) + ". ")
+				} else {
+					w.raw("- ")
+				}
+			case "a":
+				links = append(links, link{start: w.b.Len(), href: t.href})
+			case "b", "strong":
+				if markdown {
+					w.raw("**")
+				}
+			case "i", "em":
+				if markdown {
+					w.raw("_")
+				}
+			case "code":
+				if markdown && pre == 0 {
+					w.raw("`")
+				}
+			}
+		case endToken:
+			switch t.tag {
+			case "p", "div", "blockquote", "h1", "h2", "h3", "h4", "h5", "h6":
+				w.paragraph()
+			case "pre":
+				if pre > 0 {
+					pre--
+				}
+				if markdown {
+					w.newline()
+					w.raw("```")
+				}
+				w.paragraph()
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				w.paragraph()
+			case "a":
+				if len(links) == 0 {
+					continue
+				}
+				l := links[len(links)-1]
+				links = links[:len(links)-1]
+				if l.href == "" || l.start > w.b.Len() {
+					continue
+				}
+				text := strings.TrimSpace(w.b.String()[l.start:])
+				switch {
+				case markdown:
+					w.b.Truncate(l.start)
+					w.raw("[" + text + "](" + l.href + ")")
+				case text != l.href && text != strings.TrimPrefix(l.href, "mailto:"):
+					w.raw(" (" + l.href + ")")
+				}
+			case "b", "strong":
+				if markdown {
+					w.raw("**")
+				}
+			case "i", "em":
+				if markdown {
+					w.raw("_")
+				}
+			case "code":
+				if markdown && pre == 0 {
+					w.raw("`")
+				}
+			}
+		}
+	}
+
+	return strings.TrimSpace(w.b.String())
+}
+
+// writer collapses the white space of HTML text and keeps at most one empty
+// line between paragraphs.
+type writer struct {
+	b bytes.Buffer
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func (w *writer) text(s string) {
+	s = whitespace.ReplaceAllString(s, " ")
+	if w.atLineStart() || w.endsWithSpace() {
+		s = strings.TrimLeft(s, " ")
+	}
+	w.b.WriteString(s)
+}
+
+func (w *writer) raw(s string) {
+	w.b.WriteString(s)
+}
+
+func (w *writer) newline() {
+	w.trimTrailingSpace()
+	if w.b.Len() > 0 && !w.atLineStart() {
+		w.b.WriteByte('\n')
+	}
+}
+
+func (w *writer) paragraph() {
+	w.newline()
+	if w.b.Len() > 0 && !bytes.HasSuffix(w.b.Bytes(), []byte("\n\n")) {
+		w.b.WriteByte('\n')
+	}
+}
+
+func (w *writer) atLineStart() bool {
+	return w.b.Len() == 0 || bytes.HasSuffix(w.b.Bytes(), []byte("\n"))
+}
+
+func (w *writer) endsWithSpace() bool {
+	return bytes.HasSuffix(w.b.Bytes(), []byte(" "))
+}
+
+func (w *writer) trimTrailingSpace() {
+	w.b.Truncate(len(bytes.TrimRight(w.b.Bytes(), " ")))
+}
+
+var markdownSpecial = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownSpecial.Replace(s)
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,74 @@
+package notes
+
+import "testing"
+
+const showNotes = `<p>In this   episode we talk about <b>Go</b> &amp; <a href="https://golang.org" onclick="steal()">its tooling</a>.</p>
+<script>alert("hi")</script>
+<ul><li>First <em>item</em></li><li><a href="javascript:alert(1)">Second</a> item</li></ul>
+<p>Mail <a href="mailto:me@example.com">me@example.com</a><br/>or <img src="x.png">not</p>`
+
+func TestHTML(t *testing.T) {
+	table := []struct {
+		notes    string
+		expected string
+	}{
+		{
+			showNotes,
+			`<p>In this   episode we talk about <b>Go</b> &amp; <a href="https://golang.org">its tooling</a>.</p>
+
+<ul><li>First <em>item</em></li><li>Second item</li></ul>
+<p>Mail <a href="mailto:me@example.com">me@example.com</a><br>or not</p>`,
+		},
+		{"<p>unclosed <b>bold", "<p>unclosed <b>bold</b></p>"},
+		{"<b>stray</i> end</b>", "<b>stray end</b>"},
+		{"Plain text\nwith lines\n\nand paragraphs", "<p>Plain text<br>with lines</p><p>and paragraphs</p>"},
+		{`<a href="http://x" title="a > b">link</a>`, `<a href="http://x">link</a>`},
+		{"1 < 2 & 3 > 2", "<p>1 &lt; 2 &amp; 3 &gt; 2</p>"},
+	}
+
+	for _, e := range table {
+		if got := HTML(e.notes); got != e.expected {
+			t.Errorf("Expected %#v, but got: %#v", e.expected, got)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	expected := `In this episode we talk about Go & its tooling (https://golang.org).
+
+- First item
+- Second item
+
+Mail me@example.com
+or not`
+
+	if got := Text(showNotes); got != expected {
+		t.Errorf("Expected %#v, but got: %#v", expected, got)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	table := []struct {
+		notes    string
+		expected string
+	}{
+		{
+			showNotes,
+			`In this episode we talk about **Go** & [its tooling](https://golang.org).
+
+- First _item_
+- Second item
+
+Mail [me@example.com](mailto:me@example.com)
+or not`,
+		},
+		{"<h2>Links</h2><ol><li>one</li><li>two_three</li></ol>", "## Links\n\n1. one\n2. two\\_three"},
+		{"<pre>a  *b*\n c</pre>", "```\na  *b*\n c\n```"},
+	}
+
+	for _, e := range table {
+		if got := Markdown(e.notes); got != e.expected {
+			t.Errorf("Expected %#v, but got: %#v", e.expected, got)
+		}
+	}
+}
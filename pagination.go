@@ -0,0 +1,112 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/kvannotten/pcd/rss"
+)
+
+// fetchArchive follows the next links of the paginated feed, fetched from
+// pageURL, for at most ArchivePages pages and adds their items to the feed.
+// It reports whether it got to the last page. A page that can't be fetched
+// ends the walk with a warning.
+func (p *Podcast) fetchArchive(feed *rss.PodcastFeed, pageURL string) bool {
+	client := &http.Client{}
+	seen := map[string]bool{pageURL: true}
+	page := feed
+
+	for i := 0; i < p.ArchivePages; i++ {
+		next := page.Channel.NextPage()
+		if next == "" {
+			return true
+		}
+		nextURL, err := resolvePage(pageURL, next)
+		if err != nil || seen[nextURL] {
+			feed.Warnings = append(feed.Warnings, fmt.Sprintf("invalid next page %q", next))
+			return true
+		}
+		seen[nextURL] = true
+
+		page, err = p.fetchFeed(client, nextURL)
+		if err != nil {
+			log.Printf("Could not fetch %s: %v", nextURL, err)
+			feed.Warnings = append(feed.Warnings, fmt.Sprintf("could not fetch page %s: %v", nextURL, err))
+			return false
+		}
+		feed.Append(page)
+		pageURL = nextURL
+	}
+
+	return page.Channel.NextPage() == ""
+}
+
+// resolvePage resolves the url of the next page against the url of the
+// page linking to it.
+func resolvePage(pageURL, next string) (string, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	// feeds on disk can link to pages on disk, feeds on the web can't
+	if u.Scheme != "http" && u.Scheme != "https" && !(u.Scheme == "file" && base.Scheme == "file") {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return u.String(), nil
+}
+
+// mergeEpisodes adds the previously cached episodes that are missing from
+// the episodes, marking them archived when archive is set. A missing episode
+// goes right after the episode it came after before, rather than by its date,
+// so episodes without a (parsable) date stay where they were. The episodes
+// are numbered again, so the numbers of the episodes that are still around
+// only change when the feed adds episodes before them.
+func mergeEpisodes(episodes, previous []Episode, archive bool) []Episode {
+	present := make(map[string]bool)
+	for i := range episodes {
+		present[episodes[i].Key()] = true
+	}
+
+	// the missing episodes, by the key of the present episode they follow
+	after := make(map[string][]Episode)
+	anchor := ""
+	for _, episode := range previous {
+		if present[episode.Key()] {
+			anchor = episode.Key()
+			continue
+		}
+		episode.Archived = episode.Archived || archive
+		after[anchor] = append(after[anchor], episode)
+	}
+
+	merged := append([]Episode{}, after[""]...)
+	for _, episode := range episodes {
+		merged = append(merged, episode)
+		merged = append(merged, after[episode.Key()]...)
+	}
+	for i := range merged {
+		merged[i].ID = i + 1
+	}
+	return merged
+}
@@ -0,0 +1,118 @@
+package pcd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pagedFeed serves episodes 5 to 1, newest first, two on every page.
+func pagedFeed() *httptest.Server {
+	pages := map[string][]int{"": {5, 4}, "2": {4, 3, 2}, "3": {1}}
+	next := map[string]string{"": "?page=2", "2": "/feed?page=3"}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		numbers, ok := pages[page]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var b strings.Builder
+		b.WriteString(`<?xml version="1.0"?><rss xmlns:atom="http://www.w3.org/2005/Atom" version="2.0"><channel>`)
+		if next[page] != "" {
+			fmt.Fprintf(&b, `<atom:link rel="next" href="%s"/>`, next[page])
+		}
+		for _, n := range numbers {
+			fmt.Fprintf(&b, `<item><title>Episode %[1]d</title><guid>%[1]d</guid><pubDate>Mon, 0%[1]d Jan 2024 12:00:00 +0000</pubDate><enclosure url="http://example.com/%[1]d.mp3"/></item>`, n)
+		}
+		b.WriteString(`</channel></rss>`)
+		w.Write([]byte(b.String()))
+	}))
+}
+
+func episodeTitles(episodes []Episode) string {
+	var titles []string
+	for _, episode := range episodes {
+		titles = append(titles, fmt.Sprintf("%d:%s", episode.ID, episode.Title))
+	}
+	return strings.Join(titles, ",")
+}
+
+func TestSyncArchivePages(t *testing.T) {
+	ts := pagedFeed()
+	defer ts.Close()
+
+	table := []struct {
+		name  string
+		pages int
+		want  string
+	}{
+		{"first page only", 0, "1:Episode 4,2:Episode 5"},
+		{"page limit", 1, "1:Episode 2,2:Episode 3,3:Episode 4,4:Episode 5"},
+		{"last page", 5, "1:Episode 1,2:Episode 2,3:Episode 3,4:Episode 4,5:Episode 5"},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			podcast := &Podcast{Name: "test", Feed: ts.URL + "/feed", Path: randomPath(t), ArchivePages: e.pages}
+			if _, err := podcast.Sync(); err != nil {
+				t.Fatalf("Expected to be able to sync, but got: %#v", err)
+			}
+			if got := episodeTitles(podcast.Episodes); got != e.want {
+				t.Errorf("Expected %#v, but got: %#v", e.want, got)
+			}
+		})
+	}
+}
+
+func TestSyncArchivePagesKeepsCachedEpisodes(t *testing.T) {
+	ts := pagedFeed()
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Feed: ts.URL + "/feed", Path: randomPath(t), ArchivePages: 5}
+	if _, err := podcast.Sync(); err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+
+	podcast.ArchivePages = 1
+	result, err := podcast.Sync()
+	if err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+	want := "1:Episode 1,2:Episode 2,3:Episode 3,4:Episode 4,5:Episode 5"
+	if got := episodeTitles(podcast.Episodes); got != want {
+		t.Errorf("Expected %#v, but got: %#v", want, got)
+	}
+	if !result.IsEmpty() {
+		t.Errorf("Expected no differences, but got: %#v", result.Diff)
+	}
+}
+
+func TestMergeEpisodesKeepsUndatedEpisodesInPlace(t *testing.T) {
+	previous := []Episode{
+		{ID: 1, Title: "A", GUID: "a", Date: "Mon, 01 Jan 2024 12:00:00 +0000"},
+		{ID: 2, Title: "B", GUID: "b", Date: "sometime"},
+		{ID: 3, Title: "C", GUID: "c", Date: "Wed, 03 Jan 2024 12:00:00 +0000"},
+		{ID: 4, Title: "D", GUID: "d"},
+	}
+	episodes := []Episode{
+		{ID: 1, Title: "A", GUID: "a", Date: "Mon, 01 Jan 2024 12:00:00 +0000"},
+		{ID: 2, Title: "C", GUID: "c", Date: "Wed, 03 Jan 2024 12:00:00 +0000"},
+		{ID: 3, Title: "E", GUID: "e", Date: "Fri, 05 Jan 2024 12:00:00 +0000"},
+	}
+
+	merged := mergeEpisodes(episodes, previous, true)
+	want := "1:A,2:B,3:C,4:D,5:E"
+	if got := episodeTitles(merged); got != want {
+		t.Errorf("Expected %#v, but got: %#v", want, got)
+	}
+	for _, episode := range merged {
+		if archived := episode.Title == "B" || episode.Title == "D"; episode.Archived != archived {
+			t.Errorf("Expected %s to be archived: %v, but got: %v", episode.Title, archived, episode.Archived)
+		}
+	}
+}
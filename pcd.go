@@ -32,6 +32,7 @@ import (
 	urlpath "path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -47,15 +48,108 @@ type Podcast struct {
 	Username string
 	Password string
 
+	// Episodes 'pcd run' downloads: "new" for the episodes that show up in
+	// the feed, or an episode selector like "latest~3"
+	AutoDownload string `mapstructure:"auto_download"`
+
+	// Limits on the downloaded episodes kept in Path
+	Retention Retention
+
+	// Commands to run on downloads, new episodes and errors
+	Hooks Hooks
+
+	// Write the podcast and episode metadata into downloaded files
+	Tag bool
+
+	// Podcast cover and episode images kept in Path
+	Artwork Artwork
+
+	// Format of the show notes written next to downloaded episodes: md,
+	// txt or html. Empty to not write them.
+	ShowNotes string `mapstructure:"show_notes"`
+
+	// Transcript formats to download next to episodes, most preferred
+	// first, and whether to download their chapters
+	Transcripts []string
+	Chapters    bool
+
+	// Picks the file to download of episodes offering several: MIME types
+	// like "audio/mp4" or "audio/*", most preferred first, and the bitrate
+	// in kbit/s to get closest to
+	PreferredTypes   []string `mapstructure:"preferred_types"`
+	PreferredBitrate int      `mapstructure:"preferred_bitrate"`
+
+	// MIME types of the media to keep, like "audio/*", to leave the video
+	// episodes of a mixed feed out. Empty keeps everything.
+	MediaTypes []string `mapstructure:"media_types"`
+
+	// Number of older pages of a paginated feed to fetch on sync, following
+	// its atom:link rel="next". The episodes of pages further back are kept
+	// from earlier syncs.
+	ArchivePages int `mapstructure:"archive_pages"`
+
+	// Replace the episodes with the ones in the feed on sync, instead of
+	// keeping the ones that disappeared from it as archived
+	ReplaceEpisodes bool `mapstructure:"replace_episodes"`
+
 	// List of episodes
 	Episodes []Episode
 }
 
 type Episode struct {
-	ID    int
-	Title string
-	Date  string
-	URL   string
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	Date   string `json:"date"`
+	URL    string `json:"url"`
+	GUID   string `json:"guid,omitempty"`
+	Length int64  `json:"length,omitempty"`
+	// Type is the MIME type of the file at URL, guessed from its extension
+	// when the feed doesn't give it.
+	Type string `json:"type,omitempty"`
+	// Media are all the files the feed offers for the episode, URL being
+	// the one picked by the podcast's preferences.
+	Media []Media `json:"media,omitempty"`
+	// Duration is the running time of the episode in seconds, if the feed
+	// has it.
+	Duration int `json:"duration,omitempty"`
+	// Number is the episode number assigned by the publisher, if any.
+	Number int `json:"number,omitempty"`
+	// Image is the artwork of the episode, or of the podcast when the
+	// episode has none.
+	Image string `json:"image,omitempty"`
+	// Description is the episode's description or itunes:summary and
+	// ShowNotes its content:encoded. Both usually hold HTML.
+	Description string `json:"description,omitempty"`
+	ShowNotes   string `json:"show_notes,omitempty"`
+	// Transcripts and Chapters are the urls of the podcast:transcript and
+	// podcast:chapters files of the episode.
+	Transcripts []Transcript `json:"transcripts,omitempty"`
+	Chapters    string       `json:"chapters,omitempty"`
+
+	// Archived is set for episodes that disappeared from the feed, which
+	// sync keeps unless the podcast replaces its episodes.
+	Archived bool `json:"archived,omitempty"`
+
+	// Downloaded is set by Load when pcd has a record of downloading the
+	// episode into the podcast's path.
+	Downloaded bool `json:"downloaded"`
+}
+
+// SyncResult describes the outcome of a sync.
+type SyncResult struct {
+	Diff
+
+	// First is set when there was no previous sync to compare with, in which
+	// case every episode is reported as added.
+	First bool `json:"first"`
+
+	// Warnings are the problems with the feed that didn't fail the sync,
+	// like broken items that were skipped.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// MovedTo is the new url of a feed that moved permanently, by an
+	// itunes:new-feed-url or a permanent redirect.
+	MovedTo string `json:"moved_to,omitempty"`
 }
 
 var (
@@ -71,87 +165,157 @@ var (
 	ErrCouldNotParseContent  = errors.New("Could not parse the content from the feed")
 )
 
-func (p *Podcast) Sync() error {
-	client := &http.Client{}
+// Sync fetches the feed of the podcast and caches its episodes. The result
+// describes how the episodes changed since the previous sync. Besides http
+// and https urls, the feed can be a file:// url of a feed on disk.
+func (p *Podcast) Sync() (*SyncResult, error) {
+	// only the leading permanent redirects move the feed, anything after a
+	// temporary one may be gone again next time
+	var redirectedTo string
+	temporary := false
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			switch req.Response.StatusCode {
+			case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+				if !temporary {
+					redirectedTo = req.URL.String()
+				}
+			default:
+				temporary = true
+			}
+			return nil
+		},
+	}
 
-	req, err := http.NewRequest("GET", p.Feed, nil)
+	feed, err := p.fetchFeed(client, p.Feed)
 	if err != nil {
-		log.Print(err)
-		return ErrCouldNotSync
+		return nil, err
 	}
-
-	if p.Username != "" {
-		req.SetBasicAuth(p.Username, p.Password)
+	complete := true
+	if p.ArchivePages > 0 {
+		complete = p.fetchArchive(feed, p.Feed)
 	}
 
-	resp, err := client.Do(req)
+	result, err := p.update(feed, complete)
 	if err != nil {
-		log.Print(err)
-		return ErrRequestFailed
+		return nil, err
 	}
-	switch resp.StatusCode {
-	case http.StatusOK: // NOOP
-	case http.StatusForbidden, http.StatusUnauthorized:
-		return ErrAccessDenied
-	case http.StatusNotFound:
-		return ErrFeedNotFound
-	case http.StatusInternalServerError:
-		return ErrRequestFailed
-	default:
-		return ErrRequestFailed
+	if !isFileURL(p.Feed) {
+		result.MovedTo = p.movedTo(feed.Channel.NewFeedURL.URL, redirectedTo)
 	}
-	defer resp.Body.Close()
 
-	p.Episodes, err = parseEpisodes(resp.Body)
+	// artwork is nice to have, it doesn't fail the sync
+	if err := p.SyncArtwork(feed.Channel.ImageURL()); err != nil {
+		log.Printf("Could not sync artwork of %s: %v", p.Name, err)
+	}
+	return result, nil
+}
+
+// SyncFrom syncs the podcast like Sync, but reads the feed from content
+// instead of fetching it. This is meant for testing generated feeds, so the
+// next pages of a paginated feed aren't followed and no artwork is fetched.
+func (p *Podcast) SyncFrom(content io.Reader) (*SyncResult, error) {
+	feed, err := rss.ParseWith(content, rss.Options{Recover: true})
 	if err != nil {
 		log.Print(err)
-		return ErrParserIssue
+		return nil, ErrParserIssue
 	}
+	return p.update(feed, true)
+}
+
+// update makes the episodes of the feed the podcast's and caches them.
+// complete is set when the feed has all the episodes there are, rather than
+// only the pages of an archive that were fetched.
+func (p *Podcast) update(feed *rss.PodcastFeed, complete bool) (*SyncResult, error) {
+	var warnings []string
+	p.Episodes, warnings = p.feedEpisodes(feed)
 
 	if err := os.MkdirAll(p.Path, os.ModePerm); err != nil {
 		log.Print(err)
-		return ErrFilesystemError
+		return nil, ErrFilesystemError
+	}
+
+	// a missing or unreadable cache just means there is nothing to compare
+	// against
+	previous, cacheErr := readCache(p.Path)
+	// the episodes beyond the pages fetched of an archive aren't gone, they
+	// are kept as they were, unless they are of media types left out since
+	if !p.ReplaceEpisodes || !complete {
+		p.Episodes = mergeEpisodes(p.Episodes, p.allowedEpisodes(previous), complete)
+	}
+	diff := DiffEpisodes(previous, p.Episodes)
+	if len(diff.Modified) > 0 {
+		state, err := LoadState(p.Path)
+		if err != nil {
+			return nil, err
+		}
+		if state.applyChanges(diff.Modified) {
+			if err := state.Save(p.Path); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	path := filepath.Join(p.Path, ".feed")
 	f, err := os.Create(path)
 	if err != nil {
 		log.Print(err)
-		return ErrFilesystemError
+		return nil, ErrFilesystemError
 	}
 	defer f.Close()
 
 	blob, err := toGOB64(p.Episodes)
 	if err != nil {
 		log.Print(err)
-		return ErrEncodeError
+		return nil, ErrEncodeError
 	}
 	if _, err := io.Copy(f, blob); err != nil {
 		log.Print(err)
-		return ErrFilesystemError
+		return nil, ErrFilesystemError
 	}
 
-	return nil
+	return &SyncResult{
+		Diff:     diff,
+		First:    cacheErr != nil,
+		Warnings: append(feed.Warnings, warnings...),
+	}, nil
 }
 
 func (p *Podcast) Load() error {
-	path := filepath.Join(p.Path, ".feed")
-	f, err := os.Open(path)
+	var err error
+
+	p.Episodes, err = readCache(p.Path)
 	if err != nil {
-		log.Printf("Could not open feed file: %#v", err)
+		log.Printf("Could not read episodes: %#v", err)
 		return ErrCouldNotReadFromCache
 	}
-	defer f.Close()
 
-	p.Episodes, err = fromGOB64(f)
+	state, err := LoadState(p.Path)
 	if err != nil {
-		log.Printf("Could not decode episodes: %#v", err)
-		return ErrCouldNotReadFromCache
+		return err
+	}
+	for i := range p.Episodes {
+		download := state.Find(&p.Episodes[i])
+		p.Episodes[i].Downloaded = download != nil && !download.Pruned
 	}
 
 	return nil
 }
 
+// readCache returns the episodes stored in the .feed file by the last sync.
+func readCache(path string) ([]Episode, error) {
+	f, err := os.Open(filepath.Join(path, ".feed"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return fromGOB64(f)
+}
+
 const (
 	titleLength = 60
 )
@@ -172,21 +336,119 @@ func (p *Podcast) String() string {
 		tl = titleLength
 	}
 
-	for index, episode := range p.Episodes {
+	for _, episode := range p.Episodes {
 		title := episode.Title
 		if len(episode.Title) > titleLength {
 			title = fmt.Sprintf("%s...", episode.Title[0:(titleLength-4)])
 		}
-		formatStr := fmt.Sprintf("%%-4d %%-%ds %%20s\n", tl)
-		sb.WriteString(fmt.Sprintf(formatStr, index+1, title, episode.Date))
+		typ, size := "-", "-"
+		if episode.Type != "" {
+			typ = episode.Type
+		}
+		if episode.Length > 0 {
+			size = FormatSize(episode.Length)
+		}
+		formatStr := fmt.Sprintf("%%-4d %%-%ds %%20s  %%-12s %%10s", tl)
+		sb.WriteString(fmt.Sprintf(formatStr, episode.ID, title, episode.Date, typ, size))
+		if episode.Archived {
+			sb.WriteString("  archived")
+		}
+		sb.WriteString("\n")
 	}
 
 	return sb.String()
 }
 
+// fetchFeed fetches and parses the feed at the url.
+func (p *Podcast) fetchFeed(client *http.Client, url string) (*rss.PodcastFeed, error) {
+	if isFileURL(url) {
+		return readFeedFile(url)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrCouldNotSync
+	}
+
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrRequestFailed
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK: // NOOP
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return nil, ErrAccessDenied
+	case http.StatusNotFound:
+		return nil, ErrFeedNotFound
+	case http.StatusInternalServerError:
+		return nil, ErrRequestFailed
+	default:
+		return nil, ErrRequestFailed
+	}
+
+	feed, err := rss.ParseWith(resp.Body, rss.Options{
+		ContentType: resp.Header.Get("Content-Type"),
+		Recover:     true,
+	})
+	if err != nil {
+		log.Print(err)
+		return nil, ErrParserIssue
+	}
+	return feed, nil
+}
+
+// movedTo returns the url the feed moved to, if it did. The publisher's
+// itunes:new-feed-url takes precedence over permanent redirects.
+func (p *Podcast) movedTo(newFeedURL, redirectedTo string) string {
+	newFeedURL = strings.TrimSpace(newFeedURL)
+	if u, err := url.Parse(newFeedURL); err == nil && (u.Scheme == "http" || u.Scheme == "https") && newFeedURL != p.Feed {
+		return newFeedURL
+	}
+	if redirectedTo != p.Feed {
+		return redirectedTo
+	}
+	return ""
+}
+
+// Key identifies the episode across syncs. The guid is preferred, but not
+// every feed provides one, so the enclosure url is used as a fallback.
+func (e *Episode) Key() string {
+	if e.GUID != "" {
+		return e.GUID
+	}
+	return e.URL
+}
+
+// PubDate returns the parsed publication date of the episode, or the zero
+// time when the feed uses a format we don't understand.
+func (e *Episode) PubDate() time.Time {
+	return rss.ParseDate(e.Date)
+}
+
 // Download downloads an episode in 'path'. The writer argument is optional
 // and will just mirror everything written into it (useful for tracking the speed)
 func (e *Episode) Download(path string, writer io.Writer, filenameTemplate string) error {
+	return e.download(path, writer, filenameTemplate, false)
+}
+
+// Redownload downloads an episode in 'path' like Download, but replaces the
+// file of an earlier download instead of failing on it.
+func (e *Episode) Redownload(path string, writer io.Writer, filenameTemplate string) error {
+	return e.download(path, writer, filenameTemplate, true)
+}
+
+func (e *Episode) download(path string, writer io.Writer, filenameTemplate string, replace bool) error {
+	if e.URL == "" {
+		return ErrNoMedia
+	}
+
 	u, err := url.Parse(e.URL)
 	if err != nil {
 		log.Printf("Parse episode url failed: %#v", err)
@@ -206,7 +468,7 @@ func (e *Episode) Download(path string, writer io.Writer, filenameTemplate strin
 	filename := parseFilenameTemplate(filenameTemplate, e, urlpath.Base(u.Path))
 	fpath := filepath.Join(path, filename)
 
-	if _, err := os.Stat(fpath); !os.IsNotExist(err) {
+	if _, err := os.Stat(fpath); !replace && !os.IsNotExist(err) {
 		return ErrFilesystemError
 	}
 
@@ -221,12 +483,14 @@ func (e *Episode) Download(path string, writer io.Writer, filenameTemplate strin
 		return ErrCouldNotDownload
 	}
 
-	f, err := os.Create(fpath)
+	// the file only takes its name once it is complete, so a failed
+	// download leaves an earlier one alone
+	f, err := os.CreateTemp(filepath.Dir(fpath), "."+filepath.Base(fpath)+".*.part")
 	if err != nil {
 		log.Printf("Could not create file: %#v", err)
 		return ErrCouldNotDownload
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
 
 	var mw io.Writer
 
@@ -235,12 +499,21 @@ func (e *Episode) Download(path string, writer io.Writer, filenameTemplate strin
 	} else {
 		mw = f
 	}
-	if _, err := io.Copy(mw, res.Body); err != nil {
+	size, err := io.Copy(mw, res.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		log.Printf("Could not write to file: %#v", err)
 		return ErrCouldNotDownload
 	}
 
-	return nil
+	if err := os.Rename(f.Name(), fpath); err != nil {
+		log.Printf("Could not move file into place: %#v", err)
+		return ErrCouldNotDownload
+	}
+
+	return recordDownload(path, e, filename, size)
 }
 
 func parseEpisodes(content io.Reader) ([]Episode, error) {
@@ -249,21 +522,69 @@ func parseEpisodes(content io.Reader) ([]Episode, error) {
 		return nil, ErrCouldNotParseContent
 	}
 
-	var episodes []Episode
+	var p Podcast
+	episodes, _ := p.feedEpisodes(feed)
+	return episodes, nil
+}
 
-	for i, item := range feed.Channel.Items {
+// feedEpisodes returns the episodes of the items of the feed. Items without
+// any media are left out, with a warning, as are those without media of the
+// podcast's media types.
+func (p *Podcast) feedEpisodes(feed *rss.PodcastFeed) ([]Episode, []string) {
+	var episodes []Episode
+	var warnings []string
+
+	for _, item := range feed.Channel.Items {
+		var media []Media
+		for _, m := range item.Media() {
+			if m.Type == "" {
+				m.Type = urlMediaType(m.URL)
+			}
+			media = append(media, Media(m))
+		}
+		if len(media) == 0 {
+			warnings = append(warnings, fmt.Sprintf("skipped item %q: no enclosure or media:content", item.Title.Title))
+			continue
+		}
+		if media = p.allowedMedia(media); len(media) == 0 {
+			continue
+		}
+		chosen := p.chooseMedia(media)
 
 		episode := Episode{
-			ID:    i + 1,
-			Title: item.Title.Title,
-			Date:  item.Date.Date,
-			URL:   item.Enclosure.URL,
+			ID:     len(episodes) + 1,
+			Title:  item.Title.Title,
+			Date:   item.Date.Date,
+			URL:    chosen.URL,
+			GUID:   item.GUID.GUID,
+			Length: chosen.Length,
+			Type:   chosen.Type,
+			Media:  media,
+			Image:  item.ITunesImage.Href,
 		}
+		if episode.Image == "" {
+			episode.Image = feed.Channel.ImageURL()
+		}
+		episode.Description = strings.TrimSpace(item.Description.Description)
+		if episode.Description == "" {
+			episode.Description = strings.TrimSpace(item.Summary.Summary)
+		}
+		episode.ShowNotes = strings.TrimSpace(item.Content.Content)
+		for _, transcript := range item.Transcripts {
+			episode.Transcripts = append(episode.Transcripts, Transcript{
+				URL:      transcript.URL,
+				Type:     transcript.Type,
+				Language: transcript.Language,
+			})
+		}
+		episode.Chapters = item.Chapters.URL
+		episode.Duration = int(rss.ParseDuration(item.Duration.Duration).Seconds())
+		episode.Number, _ = strconv.Atoi(strings.TrimSpace(item.Episode.Episode))
 
 		episodes = append(episodes, episode)
 	}
 
-	return episodes, nil
+	return episodes, warnings
 }
 
 var reservedChars = regexp.MustCompile(`[\\/<>|:&%*;]`)
@@ -291,11 +612,11 @@ func parseFilenameTemplate(filenameTemplate string, episode *Episode, parsedTitl
 	return reservedChars.ReplaceAllString(b.String(), "_")
 }
 
-func toGOB64(episodes []Episode) (io.Reader, error) {
+func toGOB64(v interface{}) (io.Reader, error) {
 	b := bytes.Buffer{}
 
 	e := gob.NewEncoder(&b)
-	if err := e.Encode(episodes); err != nil {
+	if err := e.Encode(v); err != nil {
 		return nil, err
 	}
 
@@ -311,12 +632,16 @@ func toGOB64(episodes []Episode) (io.Reader, error) {
 func fromGOB64(content io.Reader) ([]Episode, error) {
 	var episodes []Episode
 
-	decoder := base64.NewDecoder(base64.StdEncoding, content)
-	d := gob.NewDecoder(decoder)
-
-	if err := d.Decode(&episodes); err != nil {
+	if err := decodeGOB64(content, &episodes); err != nil {
 		return nil, err
 	}
 
 	return episodes, nil
 }
+
+func decodeGOB64(content io.Reader, v interface{}) error {
+	decoder := base64.NewDecoder(base64.StdEncoding, content)
+	d := gob.NewDecoder(decoder)
+
+	return d.Decode(v)
+}
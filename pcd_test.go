@@ -20,6 +20,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	urlpath "path"
 	"path/filepath"
@@ -38,11 +39,89 @@ func TestSync(t *testing.T) {
 		Path: randomPath(t),
 	}
 
-	if err := podcast.Sync(); err != nil {
+	if _, err := podcast.Sync(); err != nil {
 		t.Errorf("Expected to be able to sync, but could not sync: %#v", err)
 	}
 }
 
+func TestSyncMalformedFeed(t *testing.T) {
+	feed := `<?xml version="1.0"?>
+<rss version="2.0">
+<channel>
+<item><title>Caf` + "\xe9" + ` &amp; more</title><enclosure url="http://example.com/1.mp3"/></item>
+<item><title>Broken</title><enclosure url="http://example.com/2.mp3" length="n/a"/></item>
+</channel>
+</rss>`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=ISO-8859-1")
+		w.Write([]byte(feed))
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Feed: ts.URL, Path: randomPath(t)}
+	result, err := podcast.Sync()
+	if err != nil {
+		t.Fatalf("Expected to be able to sync, but got: %#v", err)
+	}
+
+	if len(podcast.Episodes) != 1 || podcast.Episodes[0].Title != "Café & more" {
+		t.Errorf("Expected %#v, but got: %#v", "Café & more", podcast.Episodes)
+	}
+	if len(result.Warnings) != 1 {
+		t.Errorf("Expected a warning for the broken item, but got: %#v", result.Warnings)
+	}
+}
+
+func TestSyncMovedFeed(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/permanent", http.StatusMovedPermanently)
+		case "/permanent":
+			http.Redirect(w, r, "/feed", http.StatusPermanentRedirect)
+		case "/temporary":
+			http.Redirect(w, r, "/feed", http.StatusFound)
+		case "/moved-then-temporary":
+			http.Redirect(w, r, "/temporary", http.StatusMovedPermanently)
+		case "/announced":
+			w.Write([]byte(strings.Replace(Podcastfeed, "<channel>", "<channel><itunes:new-feed-url>"+ts.URL+"/feed</itunes:new-feed-url>", 1)))
+		default:
+			w.Write([]byte(Podcastfeed))
+		}
+	}))
+	defer ts.Close()
+
+	table := []struct {
+		path    string
+		movedTo string
+	}{
+		{"/feed", ""},
+		{"/moved", "/feed"},
+		{"/temporary", ""},
+		{"/moved-then-temporary", "/temporary"},
+		{"/announced", "/feed"},
+	}
+
+	for _, e := range table {
+		t.Run(e.path, func(t *testing.T) {
+			podcast := &Podcast{Name: "test", Feed: ts.URL + e.path, Path: randomPath(t)}
+			result, err := podcast.Sync()
+			if err != nil {
+				t.Fatalf("Expected to be able to sync, but got: %#v", err)
+			}
+
+			want := ""
+			if e.movedTo != "" {
+				want = ts.URL + e.movedTo
+			}
+			if result.MovedTo != want {
+				t.Errorf("Expected %#v, but got: %#v", want, result.MovedTo)
+			}
+		})
+	}
+}
+
 func TestSyncBadRequest(t *testing.T) {
 	podcast := &Podcast{
 		ID:   1,
@@ -50,7 +129,7 @@ func TestSyncBadRequest(t *testing.T) {
 		Feed: "foo",
 	}
 
-	if err := podcast.Sync(); err != ErrRequestFailed {
+	if _, err := podcast.Sync(); err != ErrRequestFailed {
 		t.Errorf("Expected %#v, but got: %#v", ErrRequestFailed, err)
 	}
 }
@@ -72,7 +151,7 @@ func TestSyncPathIssue(t *testing.T) {
 		Path: "/root/access/required",
 	}
 
-	if err := podcast.Sync(); err != ErrFilesystemError {
+	if _, err := podcast.Sync(); err != ErrFilesystemError {
 		t.Errorf("Expected %#v, but got: %#v", ErrFilesystemError, err)
 	}
 }
@@ -88,7 +167,7 @@ func TestCredentials(t *testing.T) {
 		Password: "incorrect",
 	}
 
-	if err := podcast.Sync(); err != ErrAccessDenied {
+	if _, err := podcast.Sync(); err != ErrAccessDenied {
 		t.Errorf("Expected %#v, but got: %#v", ErrAccessDenied, err)
 	}
 }
@@ -111,7 +190,7 @@ func TestFeedRequestIssues(t *testing.T) {
 				Feed: ts.URL,
 			}
 
-			if err := podcast.Sync(); err != e.want {
+			if _, err := podcast.Sync(); err != e.want {
 				t.Errorf("Expected %#v, but got: %#v", e.want, err)
 			}
 
@@ -129,7 +208,7 @@ func TestEpisodes(t *testing.T) {
 		Path: randomPath(t),
 	}
 
-	if err := podcast.Sync(); err != nil {
+	if _, err := podcast.Sync(); err != nil {
 		t.Errorf("Expected no error, but got: %#v", err)
 	}
 
@@ -179,7 +258,7 @@ func TestLoad(t *testing.T) {
 		Path: randomPath(t),
 	}
 
-	if err := podcast.Sync(); err != nil {
+	if _, err := podcast.Sync(); err != nil {
 		t.Errorf("Could not sync podcast: %#v", err)
 	}
 	podcast.Episodes = nil
@@ -276,6 +355,80 @@ func TestFilenameTemplateDownload(t *testing.T) {
 	}
 }
 
+func TestDownloadRecordsState(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	path := randomPath(t)
+	episode := Episode{GUID: "guid-1", Title: "foo", URL: ts.URL + "/sample.mp3"}
+	if err := episode.Download(path, nil, ""); err != nil {
+		t.Fatalf("Expected to be able to download episode, but got: %#v", err)
+	}
+
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("Expected to be able to load state, but got: %#v", err)
+	}
+	download := state.Find(&episode)
+	if download == nil {
+		t.Fatal("Expected the download to be recorded")
+	}
+	if download.Filename != "sample.mp3" {
+		t.Errorf("Expected filename sample.mp3, but got %s", download.Filename)
+	}
+	if download.Size != int64(len(Podcastfeed)) {
+		t.Errorf("Expected size %d, but got %d", len(Podcastfeed), download.Size)
+	}
+}
+
+func TestRedownload(t *testing.T) {
+	content := "first"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	path := randomPath(t)
+	defer os.RemoveAll(path)
+	episode := Episode{GUID: "guid-1", Title: "foo", URL: ts.URL + "/sample.mp3"}
+	if err := episode.Download(path, nil, ""); err != nil {
+		t.Fatalf("Expected to be able to download episode, but got: %#v", err)
+	}
+
+	content = "the second one"
+	if err := episode.Download(path, nil, ""); err != ErrFilesystemError {
+		t.Errorf("Expected %#v, but got: %#v", ErrFilesystemError, err)
+	}
+	if err := episode.Redownload(path, nil, ""); err != nil {
+		t.Fatalf("Expected to be able to download episode again, but got: %#v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(path, "sample.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("Expected %#v, but got: %#v", content, string(got))
+	}
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := state.Find(&episode).Size; size != int64(len(content)) {
+		t.Errorf("Expected %#v, but got: %#v", int64(len(content)), size)
+	}
+
+	files, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".part") {
+			t.Errorf("Expected no partial downloads to be left behind, but got: %s", f.Name())
+		}
+	}
+}
+
 func TestInvalidDownload(t *testing.T) {
 	table := []struct {
 		name    string
@@ -0,0 +1,169 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package playlist writes M3U8, PLS and XSPF playlists.
+package playlist
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Format is a playlist format.
+type Format string
+
+// Playlist formats
+const (
+	M3U8 Format = "m3u8"
+	PLS  Format = "pls"
+	XSPF Format = "xspf"
+)
+
+var ErrUnknownFormat = errors.New("Unknown playlist format, use m3u8, pls or xspf")
+
+// Entry is an item of a playlist.
+type Entry struct {
+	Title string
+	// Location is the absolute path or the url of the file.
+	Location string
+	// Duration in seconds, zero when unknown.
+	Duration int
+}
+
+// ParseFormat returns the format with the given name. m3u is taken as
+// M3U8, as that is what gets written anyway.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "m3u8", "m3u":
+		return M3U8, nil
+	case "pls":
+		return PLS, nil
+	case "xspf":
+		return XSPF, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// FormatOf returns the format belonging to the extension of filename.
+func FormatOf(filename string) (Format, error) {
+	return ParseFormat(filepath.Ext(filename))
+}
+
+// Write writes the entries to w as a playlist in the given format.
+func Write(w io.Writer, format Format, entries []Entry) error {
+	switch format {
+	case M3U8:
+		return writeM3U8(w, entries)
+	case PLS:
+		return writePLS(w, entries)
+	case XSPF:
+		return writeXSPF(w, entries)
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+func writeM3U8(w io.Writer, entries []Entry) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "#EXTM3U")
+	for _, entry := range entries {
+		duration := entry.Duration
+		if duration == 0 {
+			duration = -1
+		}
+		fmt.Fprintf(b, "#EXTINF:%d,%s\n", duration, oneLine(entry.Title))
+		fmt.Fprintln(b, entry.Location)
+	}
+	return b.Flush()
+}
+
+func writePLS(w io.Writer, entries []Entry) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "[playlist]")
+	for i, entry := range entries {
+		duration := entry.Duration
+		if duration == 0 {
+			duration = -1
+		}
+		fmt.Fprintf(b, "File%d=%s\n", i+1, entry.Location)
+		fmt.Fprintf(b, "Title%d=%s\n", i+1, oneLine(entry.Title))
+		fmt.Fprintf(b, "Length%d=%d\n", i+1, duration)
+	}
+	fmt.Fprintf(b, "NumberOfEntries=%d\n", len(entries))
+	fmt.Fprintln(b, "Version=2")
+	return b.Flush()
+}
+
+type xspfPlaylist struct {
+	XMLName   xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version   string      `xml:"version,attr"`
+	TrackList []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	// Duration is in milliseconds
+	Duration int `xml:"duration,omitempty"`
+}
+
+func writeXSPF(w io.Writer, entries []Entry) error {
+	playlist := xspfPlaylist{Version: "1"}
+	for _, entry := range entries {
+		playlist.TrackList = append(playlist.TrackList, xspfTrack{
+			Location: locationURI(entry.Location),
+			Title:    entry.Title,
+			Duration: entry.Duration * 1000,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(playlist); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// locationURI turns a path into a file url, XSPF only takes URIs.
+func locationURI(location string) string {
+	if strings.Contains(location, "://") {
+		return location
+	}
+
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(location)}
+	if !strings.HasPrefix(u.Path, "/") {
+		// windows paths like C:/...
+		u.Path = "/" + u.Path
+	}
+	return u.String()
+}
+
+// oneLine keeps titles with line breaks from breaking line based formats.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,81 @@
+package playlist
+
+import (
+	"bytes"
+	"testing"
+)
+
+var entries = []Entry{
+	{Title: "First\nepisode", Location: "/podcasts/show/first episode.mp3", Duration: 1936},
+	{Title: "Second & last", Location: "http://nas.local/show/second.mp3"},
+}
+
+func TestWrite(t *testing.T) {
+	table := []struct {
+		format   Format
+		expected string
+	}{
+		{M3U8, `#EXTM3U
+#EXTINF:1936,First episode
+/podcasts/show/first episode.mp3
+#EXTINF:-1,Second & last
+http://nas.local/show/second.mp3
+`},
+		{PLS, `[playlist]
+File1=/podcasts/show/first episode.mp3
+Title1=First episode
+Length1=1936
+File2=http://nas.local/show/second.mp3
+Title2=Second & last
+Length2=-1
+NumberOfEntries=2
+Version=2
+`},
+		{XSPF, `<?xml version="1.0" encoding="UTF-8"?>
+<playlist xmlns="http://xspf.org/ns/0/" version="1">
+  <trackList>
+    <track>
+      <location>file:///podcasts/show/first%20episode.mp3</location>
+      <title>First&#xA;episode</title>
+      <duration>1936000</duration>
+    </track>
+    <track>
+      <location>http://nas.local/show/second.mp3</location>
+      <title>Second &amp; last</title>
+    </track>
+  </trackList>
+</playlist>
+`},
+	}
+
+	for _, e := range table {
+		var b bytes.Buffer
+		if err := Write(&b, e.format, entries); err != nil {
+			t.Fatalf("Expected no error, but got: %#v", err)
+		}
+		if b.String() != e.expected {
+			t.Errorf("Expected %s playlist:\n%s\nbut got:\n%s", e.format, e.expected, b.String())
+		}
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	table := []struct {
+		filename string
+		expected Format
+		err      error
+	}{
+		{"list.m3u8", M3U8, nil},
+		{"list.M3U", M3U8, nil},
+		{"list.pls", PLS, nil},
+		{"list.xspf", XSPF, nil},
+		{"list.txt", "", ErrUnknownFormat},
+	}
+
+	for _, e := range table {
+		format, err := FormatOf(e.filename)
+		if format != e.expected || err != e.err {
+			t.Errorf("Expected %#v, %#v for %s, but got: %#v, %#v", e.expected, e.err, e.filename, format, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kvannotten/pcd/rss"
+	"github.com/pkg/errors"
+)
+
+// Retention limits the downloaded episodes that are kept on disk. Every
+// limit is optional; an episode is removed as soon as it exceeds one of them.
+type Retention struct {
+	// KeepLast keeps the N most recent episodes.
+	KeepLast int `mapstructure:"keep_last"`
+	// KeepDays keeps the episodes published in the last N days.
+	KeepDays int `mapstructure:"keep_days"`
+	// MaxSize caps the total size of the episodes, e.g. "500MB" or "2GB".
+	// The most recent episodes are kept first.
+	MaxSize string `mapstructure:"max_size"`
+}
+
+var ErrInvalidSize = errors.New("Invalid size, use a number optionally followed by KB, MB, GB or TB")
+
+// IsZero reports whether the retention policy sets no limits at all.
+func (r *Retention) IsZero() bool {
+	return r.KeepLast <= 0 && r.KeepDays <= 0 && r.MaxSize == ""
+}
+
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmgt]?)i?b?$`)
+
+// ParseSize parses a human readable size like "750MB" or "1.5G" into bytes.
+// Units are powers of 1024.
+func ParseSize(s string) (int64, error) {
+	m := sizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, ErrInvalidSize
+	}
+
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, ErrInvalidSize
+	}
+
+	multiplier := float64(1)
+	switch m[2] {
+	case "t":
+		multiplier *= 1024
+		fallthrough
+	case "g":
+		multiplier *= 1024
+		fallthrough
+	case "m":
+		multiplier *= 1024
+		fallthrough
+	case "k":
+		multiplier *= 1024
+	}
+
+	return int64(n * multiplier), nil
+}
+
+// Prune deletes the downloaded episodes that fall outside the podcast's
+// retention policy and returns what was (or, with dryRun, would be) removed.
+// Only files recorded in the download state are ever considered, so files
+// that pcd did not create are left alone.
+func (p *Podcast) Prune(dryRun bool) ([]Download, error) {
+	if p.Retention.IsZero() {
+		return nil, nil
+	}
+
+	var maxSize int64
+	if p.Retention.MaxSize != "" {
+		var err error
+		if maxSize, err = ParseSize(p.Retention.MaxSize); err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := LoadState(p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []*Download
+	for i := range state.Downloads {
+		if !state.Downloads[i].Pruned {
+			kept = append(kept, &state.Downloads[i])
+		}
+	}
+
+	// newest first, so the limits are reached by the oldest episodes
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].published().After(kept[j].published())
+	})
+
+	cutoff := time.Now().AddDate(0, 0, -p.Retention.KeepDays)
+
+	var pruned []Download
+	var count int
+	var total int64
+	for _, download := range kept {
+		count++
+		total += download.Size
+
+		switch {
+		case p.Retention.KeepLast > 0 && count > p.Retention.KeepLast:
+		case p.Retention.KeepDays > 0 && download.published().Before(cutoff):
+		case maxSize > 0 && total > maxSize:
+		default:
+			continue
+		}
+
+		if !dryRun {
+			if err := p.removeDownload(download); err != nil {
+				// keep what was pruned so far in the state
+				state.Save(p.Path)
+				return pruned, err
+			}
+		}
+		pruned = append(pruned, *download)
+	}
+
+	if dryRun || len(pruned) == 0 {
+		return pruned, nil
+	}
+
+	return pruned, state.Save(p.Path)
+}
+
+func (p *Podcast) removeDownload(download *Download) error {
+	for _, filename := range download.files() {
+		// the state only ever holds plain file names, anything else was
+		// not written by pcd
+		if filename == "" || filepath.Base(filename) != filename {
+			log.Printf("Refusing to remove %q outside of %s", filename, p.Path)
+			continue
+		}
+
+		err := os.Remove(filepath.Join(p.Path, filename))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Could not remove file: %#v", err)
+			return ErrFilesystemError
+		}
+	}
+
+	download.Pruned = true
+	return nil
+}
+
+// published returns the publication date of the episode, or the moment it
+// was downloaded when the feed's date could not be parsed.
+func (d *Download) published() time.Time {
+	if t := rss.ParseDate(d.Date); !t.IsZero() {
+		return t
+	}
+	return d.Time
+}
+
+// files returns the names of all files written for the download.
+func (d *Download) files() []string {
+	files := []string{d.Filename}
+	for _, sidecar := range []string{d.Image, d.Notes, d.Transcript, d.Chapters} {
+		if sidecar != "" {
+			files = append(files, sidecar)
+		}
+	}
+	return files
+}
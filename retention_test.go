@@ -0,0 +1,118 @@
+package pcd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	table := []struct {
+		in   string
+		want int64
+		err  error
+	}{
+		{"1024", 1024, nil},
+		{"10KB", 10 * 1024, nil},
+		{"1.5G", 1536 * 1024 * 1024, nil},
+		{"2 GiB", 2 * 1024 * 1024 * 1024, nil},
+		{"500mb", 500 * 1024 * 1024, nil},
+		{"lots", 0, ErrInvalidSize},
+		{"", 0, ErrInvalidSize},
+	}
+
+	for _, e := range table {
+		t.Run(e.in, func(t *testing.T) {
+			got, err := ParseSize(e.in)
+			if err != e.err {
+				t.Errorf("Expected %#v, but got %#v", e.err, err)
+			}
+			if got != e.want {
+				t.Errorf("Expected %d, but got %d", e.want, got)
+			}
+		})
+	}
+}
+
+// pruneFixture creates five downloaded episodes of 100 bytes, published a
+// little less than 1 to 5 days ago, and a file that pcd didn't download.
+func pruneFixture(t *testing.T) string {
+	path := randomPath(t)
+	state := &State{}
+
+	for i := 1; i <= 5; i++ {
+		filename := string(rune('a'+i-1)) + ".mp3"
+		if err := os.WriteFile(filepath.Join(path, filename), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		state.add(Download{
+			GUID:     filename,
+			Filename: filename,
+			Size:     100,
+			Date:     time.Now().AddDate(0, 0, -i).Add(time.Hour).Format(time.RFC1123Z),
+		})
+	}
+	if err := os.WriteFile(filepath.Join(path, "mine.mp3"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := state.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrune(t *testing.T) {
+	table := []struct {
+		name      string
+		retention Retention
+		removed   []string
+	}{
+		{"no policy", Retention{}, nil},
+		{"keep last", Retention{KeepLast: 3}, []string{"d.mp3", "e.mp3"}},
+		{"keep days", Retention{KeepDays: 2}, []string{"c.mp3", "d.mp3", "e.mp3"}},
+		{"max size", Retention{MaxSize: "250"}, []string{"c.mp3", "d.mp3", "e.mp3"}},
+		{"combined", Retention{KeepLast: 4, KeepDays: 10, MaxSize: "1KB"}, []string{"e.mp3"}},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			podcast := &Podcast{Path: pruneFixture(t), Retention: e.retention}
+
+			pruned, err := podcast.Prune(true)
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %#v", err)
+			}
+			if len(pruned) != len(e.removed) {
+				t.Fatalf("Expected %d files to be pruned, but got %d", len(e.removed), len(pruned))
+			}
+			for _, filename := range e.removed {
+				if _, err := os.Stat(filepath.Join(podcast.Path, filename)); err != nil {
+					t.Errorf("Expected dry run to keep %s", filename)
+				}
+			}
+
+			pruned, err = podcast.Prune(false)
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %#v", err)
+			}
+			for i, filename := range e.removed {
+				if pruned[i].Filename != filename {
+					t.Errorf("Expected %s to be pruned, but got %s", filename, pruned[i].Filename)
+				}
+				if _, err := os.Stat(filepath.Join(podcast.Path, filename)); !os.IsNotExist(err) {
+					t.Errorf("Expected %s to be removed", filename)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(podcast.Path, "mine.mp3")); err != nil {
+				t.Errorf("Expected files not downloaded by pcd to be left alone")
+			}
+
+			// pruning again is a no-op
+			if pruned, _ := podcast.Prune(false); len(pruned) != 0 {
+				t.Errorf("Expected nothing left to prune, but got %d", len(pruned))
+			}
+		})
+	}
+}
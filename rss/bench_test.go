@@ -0,0 +1,105 @@
+package rss
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// largeFeed returns a feed with n items, one a day, newest first unless
+// oldestFirst.
+func largeFeed(n int, oldestFirst bool) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<rss xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:content="http://purl.org/rss/1.0/modules/content/" version="2.0">
+<channel>
+<title>Archive</title>
+<description>Every episode ever.</description>
+<itunes:image href="http://www.example.com/podcast-icon.jpg" />
+`)
+	start := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		number := n - i
+		if oldestFirst {
+			number = i + 1
+		}
+		fmt.Fprintf(&b, `<item>
+<title>Episode %[1]d</title>
+<guid>http://www.example.com/episodes/%[1]d</guid>
+<pubDate>%[2]s</pubDate>
+<enclosure url="http://www.example.com/episodes/%[1]d.mp3" length="%[3]d" type="audio/mpeg"/>
+<itunes:duration>01:02:03</itunes:duration>
+<itunes:episode>%[1]d</itunes:episode>
+<description>The show notes of episode %[1]d.</description>
+<content:encoded><![CDATA[<p>The show notes of episode %[1]d, with <a href="http://www.example.com/">links</a>.</p>]]></content:encoded>
+</item>
+`, number, start.AddDate(0, 0, number).Format(time.RFC1123Z), 1000000+number)
+	}
+	b.WriteString("</channel>\n</rss>\n")
+	return b.String()
+}
+
+// unmarshalFeed is how Parse used to read feeds, the whole document at once.
+func unmarshalFeed(content io.Reader) (*PodcastFeed, error) {
+	var feed PodcastFeed
+	body, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, ErrCouldNotGetContent
+	}
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, ErrCouldNotParseContent
+	}
+	sortFeedByDate(&feed)
+	return &feed, nil
+}
+
+func TestParseMatchesUnmarshal(t *testing.T) {
+	for _, feed := range []string{podcastfeed, podcastfeedRFC1123Z, largeFeed(50, false)} {
+		want, err := unmarshalFeed(strings.NewReader(feed))
+		if err != nil {
+			t.Fatalf("Did not expect error but got: %#v", err)
+		}
+		got, err := Parse(strings.NewReader(feed))
+		if err != nil {
+			t.Fatalf("Did not expect error but got: %#v", err)
+		}
+
+		want.XMLName, want.Channel.XMLName = xml.Name{}, xml.Name{}
+		if !reflect.DeepEqual(want.Channel.Items, got.Channel.Items) {
+			t.Errorf("Expected the same items as xml.Unmarshal, but they differ")
+		}
+		want.Channel.Items, got.Channel.Items = nil, nil
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("Expected %#v, but got: %#v", want, got)
+		}
+	}
+}
+
+func benchmarkParse(b *testing.B, n int, parse func(io.Reader) (*PodcastFeed, error)) {
+	feed := largeFeed(n, false)
+	b.SetBytes(int64(len(feed)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := parse(strings.NewReader(feed)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshal100(b *testing.B)   { benchmarkParse(b, 100, unmarshalFeed) }
+func BenchmarkUnmarshal10000(b *testing.B) { benchmarkParse(b, 10000, unmarshalFeed) }
+func BenchmarkParse100(b *testing.B)       { benchmarkParse(b, 100, Parse) }
+func BenchmarkParse10000(b *testing.B)     { benchmarkParse(b, 10000, Parse) }
+
+func BenchmarkParseLimit10000(b *testing.B) {
+	benchmarkParse(b, 10000, func(r io.Reader) (*PodcastFeed, error) {
+		return ParseLimit(r, 100)
+	})
+}
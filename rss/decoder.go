@@ -0,0 +1,341 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rss
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	itunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+	atomNamespace   = "http://www.w3.org/2005/Atom"
+)
+
+// Decoder reads a feed one item at a time, so the whole document never has
+// to be in memory. Archive feeds can run into tens of megabytes.
+//
+// It is lenient with what is found in the wild: feeds in other charsets than
+// UTF-8, byte order marks, HTML entities like &nbsp; and unescaped
+// ampersands are all read.
+type Decoder struct {
+	// ContentType is the Content-Type the feed was served with. Its charset
+	// takes precedence over the one in the XML declaration. It must be set
+	// before the first call to Next.
+	ContentType string
+	// Recover skips the items that can't be decoded, with a warning, instead
+	// of failing. Errors in the XML itself still fail the feed, as the
+	// decoder can't tell where the next item starts.
+	Recover bool
+
+	r        *readerError
+	d        *xml.Decoder
+	channel  Channel
+	warnings []string
+	// depth is the number of open elements.
+	depth     int
+	seenRoot  bool
+	inChannel bool
+	err       error
+}
+
+// readerError remembers the error of the underlying reader, to tell it apart
+// from an invalid document.
+type readerError struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readerError) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// NewDecoder returns a Decoder reading the feed from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: &readerError{r: r}}
+}
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16BEBOM = []byte{0xfe, 0xff}
+	utf16LEBOM = []byte{0xff, 0xfe}
+)
+
+// init sets up the XML decoder, converting the feed to UTF-8 first when the
+// byte order mark or the Content-Type tell its charset.
+func (d *Decoder) init() error {
+	br := bufio.NewReader(d.r)
+	bom, _ := br.Peek(3)
+
+	var r io.Reader = br
+	converted := true
+	switch {
+	case bytes.HasPrefix(bom, utf8BOM):
+		br.Discard(len(utf8BOM))
+		converted = false
+	case bytes.HasPrefix(bom, utf16BEBOM):
+		r = unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Reader(br)
+	case bytes.HasPrefix(bom, utf16LEBOM):
+		r = unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Reader(br)
+	default:
+		label := contentTypeCharset(d.ContentType)
+		if label == "" {
+			converted = false
+			break
+		}
+		var err error
+		if r, err = charsetReader(label, br); err != nil {
+			return err
+		}
+	}
+
+	d.d = xml.NewDecoder(r)
+	d.d.Strict = false
+	d.d.Entity = xml.HTMLEntity
+	d.d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		// the declaration is still there after converting to UTF-8
+		if converted {
+			return input, nil
+		}
+		return charsetReader(label, input)
+	}
+	return nil
+}
+
+// contentTypeCharset returns the charset parameter of the Content-Type, if
+// any.
+func contentTypeCharset(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(params["charset"])
+}
+
+// charsetReader returns a reader converting the input from the charset to
+// UTF-8. Charsets are looked up like browsers do, so ISO-8859-1 is read as
+// the Windows-1252 it usually is.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	e, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", label)
+	}
+	return e.NewDecoder().Reader(input), nil
+}
+
+// Next returns the next item of the feed, in the order of the document. It
+// returns io.EOF after the last one. Callers that have seen enough can stop
+// calling it, the rest of the document isn't read then.
+func (d *Decoder) Next() (*Item, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.d == nil {
+		if err := d.init(); err != nil {
+			d.err = d.fail(err)
+			return nil, d.err
+		}
+	}
+
+	item, err := d.next()
+	if err != nil {
+		d.err = err
+	}
+	return item, err
+}
+
+func (d *Decoder) next() (*Item, error) {
+	for {
+		token, err := d.d.Token()
+		if err == io.EOF && d.seenRoot && d.depth == 0 {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, d.fail(err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			d.depth++
+			switch {
+			case d.depth == 1:
+				if t.Name.Local != "rss" {
+					return nil, d.fail(xml.UnmarshalError("expected element type <rss> but have <" + t.Name.Local + ">"))
+				}
+				d.seenRoot = true
+			case d.depth == 2 && t.Name.Local == "channel":
+				d.inChannel = true
+			case d.depth == 3 && d.inChannel:
+				item, err := d.channelElement(&t)
+				if err != nil {
+					return nil, d.fail(err)
+				}
+				if item != nil {
+					return item, nil
+				}
+			default:
+				if err := d.skip(); err != nil {
+					return nil, d.fail(err)
+				}
+			}
+		case xml.EndElement:
+			d.depth--
+			if d.depth == 1 {
+				d.inChannel = false
+			}
+		}
+	}
+}
+
+// channelElement decodes an element of the channel. It returns the item when
+// the element is one.
+func (d *Decoder) channelElement(start *xml.StartElement) (*Item, error) {
+	// DecodeElement consumes the end element as well
+	d.depth--
+
+	var v interface{}
+	switch {
+	case start.Name.Local == "item" && d.Recover:
+		return d.recoverItem(start)
+	case start.Name.Local == "item":
+		var item Item
+		if err := d.d.DecodeElement(&item, start); err != nil {
+			return nil, err
+		}
+		return &item, nil
+	case start.Name.Space == itunesNamespace && start.Name.Local == "image":
+		v = &d.channel.ITunesImage
+	case start.Name.Space == itunesNamespace && start.Name.Local == "new-feed-url":
+		v = &d.channel.NewFeedURL
+	case start.Name.Space == atomNamespace && start.Name.Local == "link":
+		var link AtomLink
+		if err := d.d.DecodeElement(&link, start); err != nil {
+			return nil, err
+		}
+		d.channel.Links = append(d.channel.Links, link)
+		return nil, nil
+	case start.Name.Space == "" && start.Name.Local == "image":
+		v = &d.channel.Image
+	case start.Name.Space == "" && start.Name.Local == "title":
+		v = &d.channel.Title
+	case start.Name.Space == "" && start.Name.Local == "description":
+		v = &d.channel.Description
+	default:
+		return nil, d.d.Skip()
+	}
+
+	return nil, d.d.DecodeElement(v, start)
+}
+
+// recoverItem decodes the item that was just opened from a copy of its
+// tokens, so the decoder is still at the end of the item when the item turns
+// out to be broken. It returns nil for a broken item.
+func (d *Decoder) recoverItem(start *xml.StartElement) (*Item, error) {
+	line, _ := d.d.InputPos()
+
+	tokens := tokenList{start.Copy()}
+	for depth := 1; depth > 0; {
+		t, err := d.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+		tokens = append(tokens, xml.CopyToken(t))
+	}
+
+	var item Item
+	replay := tokens
+	if err := xml.NewTokenDecoder(&replay).Decode(&item); err != nil {
+		d.warnings = append(d.warnings, fmt.Sprintf("line %d: skipped item %q: %v", line, tokens.title(), err))
+		return nil, nil
+	}
+	return &item, nil
+}
+
+// Warnings returns the problems with the feed so far that didn't stop it
+// from being read.
+func (d *Decoder) Warnings() []string {
+	return d.warnings
+}
+
+// tokenList replays the tokens of an element.
+type tokenList []xml.Token
+
+func (l *tokenList) Token() (xml.Token, error) {
+	if len(*l) == 0 {
+		return nil, io.EOF
+	}
+	t := (*l)[0]
+	*l = (*l)[1:]
+	return t, nil
+}
+
+// title returns the title of the item the tokens are of.
+func (l tokenList) title() string {
+	for i, t := range l {
+		if se, ok := t.(xml.StartElement); ok && se.Name.Space == "" && se.Name.Local == "title" && i+1 < len(l) {
+			if text, ok := l[i+1].(xml.CharData); ok {
+				return strings.TrimSpace(string(text))
+			}
+		}
+	}
+	return ""
+}
+
+// skip skips the element that was just opened.
+func (d *Decoder) skip() error {
+	if err := d.d.Skip(); err != nil {
+		return err
+	}
+	d.depth--
+	return nil
+}
+
+// fail logs the error and returns the error to return for it.
+func (d *Decoder) fail(err error) error {
+	log.Print(err)
+	if d.r.err != nil {
+		return ErrCouldNotGetContent
+	}
+	return ErrCouldNotParseContent
+}
+
+// Channel returns the channel of the feed, without its items. Elements after
+// the last item read are only in it once Next returned io.EOF.
+func (d *Decoder) Channel() Channel {
+	return d.channel
+}
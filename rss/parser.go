@@ -19,15 +19,18 @@ import (
 	"encoding/xml"
 	"errors"
 	"io"
-	"io/ioutil"
-	"log"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type PodcastFeed struct {
 	XMLName xml.Name `xml:"rss"`
 	Channel Channel
+	// Warnings are the problems with the feed that didn't stop it from being
+	// parsed, like the items skipped in recovery mode.
+	Warnings []string `xml:"-"`
 }
 
 type Channel struct {
@@ -35,6 +38,18 @@ type Channel struct {
 	Items       []Item   `xml:"item"`
 	Title       ChannelTitle
 	Description ChannelDescription
+	ITunesImage ITunesImage
+	Image       ChannelImage
+	NewFeedURL  ITunesNewFeedURL
+	Links       []AtomLink `xml:"http://www.w3.org/2005/Atom link"`
+}
+
+// AtomLink is an atom:link of the channel, like the rel="next" link to the
+// next page of a paginated feed (RFC 5005).
+type AtomLink struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom link"`
+	Rel     string   `xml:"rel,attr"`
+	Href    string   `xml:"href,attr"`
 }
 
 type ChannelTitle struct {
@@ -47,11 +62,85 @@ type ChannelDescription struct {
 	Description string   `xml:",chardata"`
 }
 
+type ChannelImage struct {
+	XMLName xml.Name `xml:"image"`
+	URL     string   `xml:"url"`
+}
+
+// ITunesNewFeedURL is the itunes:new-feed-url a publisher adds to the old
+// feed when moving it.
+type ITunesNewFeedURL struct {
+	XMLName xml.Name `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd new-feed-url"`
+	URL     string   `xml:",chardata"`
+}
+
+// ITunesImage is the itunes:image of a channel or an item. It must be
+// declared before an unqualified image, which would match it as well.
+type ITunesImage struct {
+	XMLName xml.Name `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Href    string   `xml:"href,attr"`
+}
+
 type Item struct {
-	Title      ItemTitle
-	Enclosure  Enclosure
-	Downloaded bool
-	Date       PodcastDate
+	Title         ItemTitle
+	Enclosures    []Enclosure    `xml:"enclosure"`
+	MediaContents []MediaContent `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaGroups   []MediaGroup   `xml:"http://search.yahoo.com/mrss/ group"`
+	Downloaded    bool
+	Date          PodcastDate
+	GUID          ItemGUID
+	ITunesImage   ITunesImage
+	Episode       ITunesEpisode
+	Description   ItemDescription
+	Content       ItemContent
+	Summary       ITunesSummary
+	Transcripts   []Transcript `xml:"https://podcastindex.org/namespace/1.0 transcript"`
+	Chapters      Chapters
+	Duration      ITunesDuration
+}
+
+type ITunesDuration struct {
+	XMLName  xml.Name `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Duration string   `xml:",chardata"`
+}
+
+// Transcript is a podcast:transcript of an item. An item can have one per
+// format and language.
+type Transcript struct {
+	XMLName  xml.Name `xml:"https://podcastindex.org/namespace/1.0 transcript"`
+	URL      string   `xml:"url,attr"`
+	Type     string   `xml:"type,attr"`
+	Language string   `xml:"language,attr"`
+}
+
+// Chapters is the podcast:chapters of an item, a JSON file with the chapter
+// markers.
+type Chapters struct {
+	XMLName xml.Name `xml:"https://podcastindex.org/namespace/1.0 chapters"`
+	URL     string   `xml:"url,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+type ItemDescription struct {
+	XMLName     xml.Name `xml:"description"`
+	Description string   `xml:",chardata"`
+}
+
+// ItemContent is the content:encoded of an item, which usually holds the
+// full show notes as HTML.
+type ItemContent struct {
+	XMLName xml.Name `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Content string   `xml:",chardata"`
+}
+
+type ITunesSummary struct {
+	XMLName xml.Name `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	Summary string   `xml:",chardata"`
+}
+
+type ITunesEpisode struct {
+	XMLName xml.Name `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	Episode string   `xml:",chardata"`
 }
 
 type ItemTitle struct {
@@ -59,6 +148,11 @@ type ItemTitle struct {
 	Title   string   `xml:",chardata"`
 }
 
+type ItemGUID struct {
+	XMLName xml.Name `xml:"guid"`
+	GUID    string   `xml:",chardata"`
+}
+
 type ItemLink struct {
 	XMLName xml.Name `xml:"link"`
 	Link    string   `xml:",chardata"`
@@ -68,6 +162,27 @@ type Enclosure struct {
 	XMLName xml.Name `xml:"enclosure"`
 	URL     string   `xml:"url,attr"`
 	Type    string   `xml:"type,attr"`
+	Length  int64    `xml:"length,attr"`
+}
+
+// MediaContent is a media:content of an item or a media:group. Its numeric
+// attributes are kept as text, publishers fill them in loosely.
+type MediaContent struct {
+	XMLName   xml.Name `xml:"http://search.yahoo.com/mrss/ content"`
+	URL       string   `xml:"url,attr"`
+	Type      string   `xml:"type,attr"`
+	Medium    string   `xml:"medium,attr"`
+	FileSize  string   `xml:"fileSize,attr"`
+	Bitrate   string   `xml:"bitrate,attr"`
+	Duration  string   `xml:"duration,attr"`
+	IsDefault string   `xml:"isDefault,attr"`
+}
+
+// MediaGroup is a media:group, alternatives of the same media, like
+// several formats or bitrates.
+type MediaGroup struct {
+	XMLName  xml.Name       `xml:"http://search.yahoo.com/mrss/ group"`
+	Contents []MediaContent `xml:"http://search.yahoo.com/mrss/ content"`
 }
 
 type PodcastDate struct {
@@ -75,45 +190,267 @@ type PodcastDate struct {
 	Date    string   `xml:",chardata"`
 }
 
+// ImageURL returns the artwork of the channel, preferring itunes:image.
+func (c *Channel) ImageURL() string {
+	if c.ITunesImage.Href != "" {
+		return c.ITunesImage.Href
+	}
+	return strings.TrimSpace(c.Image.URL)
+}
+
+// Media is a media file of an item, from an enclosure or a media:content.
+type Media struct {
+	URL    string
+	Type   string
+	Length int64
+	// Bitrate is in kbit/s. When the feed doesn't give it, it is estimated
+	// from the length and the duration, or zero when those are unknown.
+	Bitrate int
+	// Default is set for the media:content marked as the default one.
+	Default bool
+}
+
+// Media returns the media files of the item: the enclosures, then the
+// media:content of the media:groups, then the other media:content. Images
+// and documents are left out, as are files already in the list.
+func (i *Item) Media() []Media {
+	var media []Media
+	seen := make(map[string]bool)
+	add := func(m Media) {
+		m.URL = strings.TrimSpace(m.URL)
+		if m.URL == "" || seen[m.URL] {
+			return
+		}
+		seen[m.URL] = true
+		media = append(media, m)
+	}
+
+	duration := ParseDuration(i.Duration.Duration)
+	for _, enclosure := range i.Enclosures {
+		add(Media{
+			URL:     enclosure.URL,
+			Type:    enclosure.Type,
+			Length:  enclosure.Length,
+			Bitrate: estimateBitrate(enclosure.Length, duration),
+		})
+	}
+
+	var contents []MediaContent
+	for _, group := range i.MediaGroups {
+		contents = append(contents, group.Contents...)
+	}
+	contents = append(contents, i.MediaContents...)
+	for _, content := range contents {
+		if !content.playable() {
+			continue
+		}
+		m := Media{
+			URL:     content.URL,
+			Type:    content.Type,
+			Default: strings.TrimSpace(content.IsDefault) == "true",
+		}
+		m.Length, _ = strconv.ParseInt(strings.TrimSpace(content.FileSize), 10, 64)
+		if bitrate, err := strconv.ParseFloat(strings.TrimSpace(content.Bitrate), 64); err == nil {
+			m.Bitrate = int(bitrate)
+		} else if d := ParseDuration(content.Duration); d > 0 {
+			m.Bitrate = estimateBitrate(m.Length, d)
+		} else {
+			m.Bitrate = estimateBitrate(m.Length, duration)
+		}
+		add(m)
+	}
+
+	return media
+}
+
+// playable tells whether the media:content is audio or video, as far as it
+// says.
+func (c *MediaContent) playable() bool {
+	switch strings.ToLower(strings.TrimSpace(c.Medium)) {
+	case "image", "document", "executable":
+		return false
+	}
+	t := strings.ToLower(c.Type)
+	return !strings.HasPrefix(t, "image/") && !strings.HasPrefix(t, "text/")
+}
+
+func estimateBitrate(length int64, duration time.Duration) int {
+	if length <= 0 || duration < time.Second {
+		return 0
+	}
+	return int(float64(length) * 8 / duration.Seconds() / 1000)
+}
+
+// NextPage returns the url of the next, older, page of a paginated feed, or
+// an empty string on the last page. It can be relative to the page's url.
+func (c *Channel) NextPage() string {
+	for _, link := range c.Links {
+		if strings.EqualFold(strings.TrimSpace(link.Rel), "next") {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	return ""
+}
+
+// Append adds the items of another page of the feed that aren't in it yet,
+// keeping the items sorted by date. Items are the same when they have the
+// same guid, or else the same first media file.
+func (f *PodcastFeed) Append(page *PodcastFeed) {
+	seen := make(map[string]bool)
+	for i := range f.Channel.Items {
+		seen[f.Channel.Items[i].key()] = true
+	}
+	for _, item := range page.Channel.Items {
+		if key := item.key(); key == "" || !seen[key] {
+			seen[key] = true
+			f.Channel.Items = append(f.Channel.Items, item)
+		}
+	}
+	f.Warnings = append(f.Warnings, page.Warnings...)
+	sortFeedByDate(f)
+}
+
+func (i *Item) key() string {
+	if guid := strings.TrimSpace(i.GUID.GUID); guid != "" {
+		return "guid:" + guid
+	}
+	if media := i.Media(); len(media) > 0 {
+		return "url:" + media[0].URL
+	}
+	return ""
+}
+
 var (
 	ErrCouldNotGetContent   = errors.New("Could not get content")
 	ErrCouldNotParseContent = errors.New("Could not parse content")
 )
 
+// Options change how a feed is parsed.
+type Options struct {
+	// ContentType is the Content-Type the feed was served with. Its charset
+	// takes precedence over the one in the XML declaration.
+	ContentType string
+	// Limit keeps only the newest items, see ParseLimit.
+	Limit int
+	// Recover skips the items that can't be decoded, with a warning, instead
+	// of failing the whole feed.
+	Recover bool
+}
+
+// Parse parses the feed, with its items sorted oldest first.
 func Parse(content io.Reader) (*PodcastFeed, error) {
+	return ParseWith(content, Options{})
+}
+
+// ParseLimit parses the feed like Parse, but only keeps its n newest items,
+// or all of them when n is zero. The items are decoded one at a time, so at
+// most 2n of them are in memory at once.
+func ParseLimit(content io.Reader, n int) (*PodcastFeed, error) {
+	return ParseWith(content, Options{Limit: n})
+}
+
+// ParseWith parses the feed with the options.
+func ParseWith(content io.Reader, opts Options) (*PodcastFeed, error) {
 	if content == nil {
 		return nil, ErrCouldNotGetContent
 	}
 
-	var feed PodcastFeed
-	body, err := ioutil.ReadAll(content)
-	if err != nil {
-		log.Print(err)
-		return nil, ErrCouldNotGetContent
+	var items []Item
+	d := NewDecoder(content)
+	d.ContentType = opts.ContentType
+	d.Recover = opts.Recover
+	n := opts.Limit
+	for {
+		item, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, *item)
+		if n > 0 && len(items) >= 2*n {
+			items = newest(items, n)
+		}
 	}
-	if err := xml.Unmarshal(body, &feed); err != nil {
-		log.Print(err)
-		return nil, ErrCouldNotParseContent
+	if n > 0 && len(items) > n {
+		items = newest(items, n)
 	}
+
+	feed := PodcastFeed{Channel: d.Channel(), Warnings: d.Warnings()}
+	feed.Channel.Items = items
 	sortFeedByDate(&feed)
 	return &feed, nil
 }
 
-func stringToDate(d string) time.Time {
-	var t time.Time
-	var err error
+// newest returns the n newest items. Of items with the same date, the ones
+// earliest in the feed are kept.
+func newest(items []Item, n int) []Item {
+	dates := make([]time.Time, len(items))
+	order := make([]int, len(items))
+	for i := range items {
+		dates[i] = ParseDate(items[i].Date.Date)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return dates[order[i]].After(dates[order[j]])
+	})
+
+	kept := make([]Item, 0, 2*n)
+	for _, i := range order[:n] {
+		kept = append(kept, items[i])
+	}
+	return kept
+}
+
+// dateFormats are the layouts tried, in order, when parsing publication dates.
+// RSS mandates RFC 822 dates, but publishers are creative.
+var dateFormats = []string{
+	time.RFC1123,
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+}
+
+// ParseDuration parses an itunes:duration, given as seconds, MM:SS or
+// HH:MM:SS. It returns zero for anything else.
+func ParseDuration(d string) time.Duration {
+	parts := strings.Split(strings.TrimSpace(d), ":")
+	if len(parts) > 3 {
+		return 0
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
 
-	t, err = time.Parse(time.RFC1123, d)
-	if err != nil {
-		t, _ = time.Parse(time.RFC1123Z, d)
+// ParseDate parses the publication date of an item. It returns the zero time
+// when the date is in none of the known formats.
+func ParseDate(d string) time.Time {
+	d = strings.TrimSpace(d)
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, d); err == nil {
+			return t
+		}
 	}
-	return t
+	return time.Time{}
 }
 
 func sortFeedByDate(feed *PodcastFeed) {
-	sort.Slice(feed.Channel.Items, func(i, j int) bool {
-		d1 := stringToDate(feed.Channel.Items[i].Date.Date)
-		d2 := stringToDate(feed.Channel.Items[j].Date.Date)
+	sort.SliceStable(feed.Channel.Items, func(i, j int) bool {
+		d1 := ParseDate(feed.Channel.Items[i].Date.Date)
+		d2 := ParseDate(feed.Channel.Items[j].Date.Date)
 
 		return d2.After(d1)
 	})
@@ -3,8 +3,10 @@ package rss
 import (
 	"errors"
 	"io"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 var podcastfeed = `<?xml version="1.0" encoding="UTF-8"?>
@@ -321,3 +323,242 @@ func TestSort(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDuration(t *testing.T) {
+	table := []struct {
+		duration string
+		expected time.Duration
+	}{
+		{"00:32:16", 32*time.Minute + 16*time.Second},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{"45:10", 45*time.Minute + 10*time.Second},
+		{"3600", time.Hour},
+		{" 90.5 ", 90*time.Second + 500*time.Millisecond},
+		{"", 0},
+		{"1:2:3:4", 0},
+		{"about an hour", 0},
+	}
+
+	for _, e := range table {
+		if got := ParseDuration(e.duration); got != e.expected {
+			t.Errorf("Expected %#v for %q, but got: %#v", e.expected, e.duration, got)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" version="2.0">
+<channel>
+<title>Title of Podcast</title>
+<item><title>First</title><guid>1</guid></item>
+<extra><item><title>Not an item</title></item></extra>
+<item><title>Second</title><guid>2</guid></item>
+<itunes:image href="http://www.example.com/podcast-icon.jpg" />
+</channel>
+</rss>`
+
+	d := NewDecoder(strings.NewReader(feed))
+	var titles []string
+	for {
+		item, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Did not expect error but got: %#v", err)
+		}
+		titles = append(titles, item.Title.Title)
+	}
+
+	if strings.Join(titles, ",") != "First,Second" {
+		t.Errorf("Expected %#v, but got: %#v", "First,Second", titles)
+	}
+	channel := d.Channel()
+	if channel.Title.Title != "Title of Podcast" {
+		t.Errorf("Expected %#v, but got: %#v", "Title of Podcast", channel.Title.Title)
+	}
+	if channel.ImageURL() != "http://www.example.com/podcast-icon.jpg" {
+		t.Errorf("Expected %#v, but got: %#v", "http://www.example.com/podcast-icon.jpg", channel.ImageURL())
+	}
+}
+
+func TestDecoderInvalidContent(t *testing.T) {
+	table := []struct {
+		name    string
+		content string
+	}{
+		{"not rss", "<feed><entry/></feed>"},
+		{"truncated", "<rss><channel><item><title>First</title></item><item><title>Sec"},
+		{"empty", ""},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(e.content))
+			var err error
+			for err == nil {
+				_, err = d.Next()
+			}
+			if err != ErrCouldNotParseContent {
+				t.Errorf("Expected %#v, but got: %#v", ErrCouldNotParseContent, err)
+			}
+		})
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	table := []struct {
+		name  string
+		feed  string
+		limit int
+		want  []string
+	}{
+		{"newest first", largeFeed(10, false), 3, []string{"Episode 8", "Episode 9", "Episode 10"}},
+		{"oldest first", largeFeed(10, true), 3, []string{"Episode 8", "Episode 9", "Episode 10"}},
+		{"compacted several times", largeFeed(25, true), 2, []string{"Episode 24", "Episode 25"}},
+		{"more than there are", largeFeed(2, false), 5, []string{"Episode 1", "Episode 2"}},
+		{"no limit", largeFeed(4, false), 0, []string{"Episode 1", "Episode 2", "Episode 3", "Episode 4"}},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			feed, err := ParseLimit(strings.NewReader(e.feed), e.limit)
+			if err != nil {
+				t.Fatalf("Did not expect error but got: %#v", err)
+			}
+			var got []string
+			for _, item := range feed.Channel.Items {
+				got = append(got, item.Title.Title)
+			}
+			if strings.Join(got, ",") != strings.Join(e.want, ",") {
+				t.Errorf("Expected %#v, but got: %#v", e.want, got)
+			}
+		})
+	}
+}
+
+// tolerantFeed returns a feed with one item with the title, declared in the
+// encoding.
+func tolerantFeed(encoding, title string) string {
+	return `<?xml version="1.0" encoding="` + encoding + `"?>
+<rss version="2.0">
+<channel>
+<title>Title of Podcast</title>
+<item><title>` + title + `</title><guid>1</guid></item>
+</channel>
+</rss>`
+}
+
+func utf16LE(s string) string {
+	b := []byte{0xff, 0xfe}
+	for _, r := range s {
+		b = append(b, byte(r), byte(r>>8))
+	}
+	return string(b)
+}
+
+func TestParseTolerant(t *testing.T) {
+	table := []struct {
+		name        string
+		feed        string
+		contentType string
+		want        string
+	}{
+		{"iso-8859-1", tolerantFeed("ISO-8859-1", "Caf\xe9"), "", "Café"},
+		{"windows-1252", tolerantFeed("windows-1252", "It\x92s"), "", "It’s"},
+		{"content type charset", tolerantFeed("UTF-8", "Caf\xe9"), "application/rss+xml; charset=iso-8859-1", "Café"},
+		{"content type without charset", tolerantFeed("UTF-8", "Café"), "text/xml", "Café"},
+		{"utf-8 byte order mark", "\xef\xbb\xbf" + tolerantFeed("UTF-8", "Café"), "", "Café"},
+		{"utf-16 byte order mark", utf16LE(tolerantFeed("UTF-16", "Café")), "", "Café"},
+		{"html entities", tolerantFeed("UTF-8", "Q&amp;A&nbsp;&eacute;"), "", "Q&A é"},
+		{"unescaped ampersand", tolerantFeed("UTF-8", "Q & A"), "", "Q & A"},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			feed, err := ParseWith(strings.NewReader(e.feed), Options{ContentType: e.contentType})
+			if err != nil {
+				t.Fatalf("Did not expect error but got: %#v", err)
+			}
+			if got := feed.Channel.Items[0].Title.Title; got != e.want {
+				t.Errorf("Expected %#v, but got: %#v", e.want, got)
+			}
+		})
+	}
+}
+
+func TestParseUnknownCharset(t *testing.T) {
+	_, err := Parse(strings.NewReader(tolerantFeed("klingon", "Qapla'")))
+	if err != ErrCouldNotParseContent {
+		t.Errorf("Expected %#v, but got: %#v", ErrCouldNotParseContent, err)
+	}
+}
+
+func TestParseRecover(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<item><title>First</title><guid>1</guid></item>
+<item>
+  <title>Broken</title>
+  <enclosure url="http://www.example.com/2.mp3" length="about 20MB" type="audio/mpeg"/>
+</item>
+<item><title>Third</title><guid>3</guid></item>
+</channel>
+</rss>`
+
+	if _, err := Parse(strings.NewReader(feed)); err != ErrCouldNotParseContent {
+		t.Errorf("Expected %#v, but got: %#v", ErrCouldNotParseContent, err)
+	}
+
+	parsed, err := ParseWith(strings.NewReader(feed), Options{Recover: true})
+	if err != nil {
+		t.Fatalf("Did not expect error but got: %#v", err)
+	}
+	var titles []string
+	for _, item := range parsed.Channel.Items {
+		titles = append(titles, item.Title.Title)
+	}
+	if strings.Join(titles, ",") != "First,Third" {
+		t.Errorf("Expected %#v, but got: %#v", "First,Third", titles)
+	}
+	if len(parsed.Warnings) != 1 || !strings.Contains(parsed.Warnings[0], `line 5: skipped item "Broken"`) {
+		t.Errorf("Expected a warning for the broken item, but got: %#v", parsed.Warnings)
+	}
+}
+
+func TestItemMedia(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:media="http://search.yahoo.com/mrss/" version="2.0">
+<channel>
+<item>
+  <title>Episode</title>
+  <itunes:duration>10:00</itunes:duration>
+  <enclosure url="http://example.com/episode.mp3" length="9600000" type="audio/mpeg"/>
+  <media:group>
+    <media:content url="http://example.com/episode.m4a" fileSize="4800000" type="audio/mp4" bitrate="64"/>
+    <media:content url="http://example.com/episode.opus" type="audio/ogg" duration="300" fileSize="1200000" isDefault="true"/>
+  </media:group>
+  <media:content url="http://example.com/episode.mp3" type="audio/mpeg"/>
+  <media:content url="http://example.com/cover.jpg" medium="image"/>
+  <media:content url="http://example.com/notes.html" type="text/html"/>
+</item>
+</channel>
+</rss>`
+
+	parsed, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("Did not expect error but got: %#v", err)
+	}
+
+	want := []Media{
+		{URL: "http://example.com/episode.mp3", Type: "audio/mpeg", Length: 9600000, Bitrate: 128},
+		{URL: "http://example.com/episode.m4a", Type: "audio/mp4", Length: 4800000, Bitrate: 64},
+		{URL: "http://example.com/episode.opus", Type: "audio/ogg", Length: 1200000, Bitrate: 32, Default: true},
+	}
+	got := parsed.Channel.Items[0].Media()
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected %#v, but got: %#v", want, got)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kvannotten/pcd/notes"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidShowNotesFormat = errors.New("Invalid show notes format, use md, txt or html")
+
+// Notes returns the show notes of the episode, falling back to its
+// description.
+func (e *Episode) Notes() string {
+	if e.ShowNotes != "" {
+		return e.ShowNotes
+	}
+	return e.Description
+}
+
+// WriteShowNotes writes the show notes of a downloaded episode next to its
+// audio file, in the format set in the podcast's ShowNotes. The file has the
+// name of the audio file with the extension of the format.
+func (p *Podcast) WriteShowNotes(e *Episode) error {
+	if e.Notes() == "" {
+		return nil
+	}
+
+	var content string
+	switch p.ShowNotes {
+	case "md":
+		content = "# " + e.Title + "\n\n" + e.Date + "\n\n" + notes.Markdown(e.Notes()) + "\n"
+	case "txt":
+		content = e.Title + "\n" + e.Date + "\n\n" + notes.Text(e.Notes()) + "\n"
+	case "html":
+		title := html.EscapeString(e.Title)
+		content = "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + title + "</title>\n</head>\n<body>\n" +
+			"<h1>" + title + "</h1>\n<p>" + html.EscapeString(e.Date) + "</p>\n" +
+			notes.HTML(e.Notes()) + "\n</body>\n</html>\n"
+	default:
+		return ErrInvalidShowNotesFormat
+	}
+
+	state, err := LoadState(p.Path)
+	if err != nil {
+		return err
+	}
+	download := state.Find(e)
+	if download == nil || download.Pruned {
+		return ErrNotDownloaded
+	}
+
+	filename := strings.TrimSuffix(download.Filename, filepath.Ext(download.Filename)) + "." + p.ShowNotes
+	if filename == download.Filename {
+		// an episode saved as .txt keeps its own file
+		filename += "." + p.ShowNotes
+	}
+	if err := os.WriteFile(filepath.Join(p.Path, filename), []byte(content), 0644); err != nil {
+		log.Printf("Could not write show notes: %#v", err)
+		return ErrFilesystemError
+	}
+
+	download.Notes = filename
+	return state.Save(p.Path)
+}
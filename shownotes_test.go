@@ -0,0 +1,80 @@
+package pcd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseShowNotes(t *testing.T) {
+	feed := strings.Replace(Podcastfeed, `<rss `, `<rss xmlns:content="http://purl.org/rss/1.0/modules/content/" `, 1)
+	feed = strings.Replace(feed, "<guid>", `<content:encoded><![CDATA[<p>Full <b>notes</b></p>]]></content:encoded><guid>`, 1)
+
+	episodes, err := parseEpisodes(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("Expected to be able to parse, but got: %#v", err)
+	}
+	if episodes[0].Description != "Description of podcast episode content" {
+		t.Errorf("Expected the description to be parsed, but got %#v", episodes[0].Description)
+	}
+	if episodes[0].ShowNotes != "<p>Full <b>notes</b></p>" {
+		t.Errorf("Expected content:encoded to be parsed, but got %#v", episodes[0].ShowNotes)
+	}
+
+	// itunes:summary is used when there is no description
+	feed = strings.Replace(Podcastfeed, "<description>Description of podcast episode content</description>", "", 1)
+	episodes, _ = parseEpisodes(strings.NewReader(feed))
+	if episodes[0].Description != "Description of podcast episode content" || episodes[0].Notes() != episodes[0].Description {
+		t.Errorf("Expected the summary to be used, but got %#v", episodes[0].Description)
+	}
+}
+
+func TestWriteShowNotes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("audio"))
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Path: randomPath(t)}
+	defer os.RemoveAll(podcast.Path)
+
+	episode := &Episode{
+		Title:     "Episode <1>",
+		Date:      "Wed, 21 Dec 2016 16:01:07 +0000",
+		URL:       ts.URL + "/episode.mp3",
+		ShowNotes: `<p>See <a href="https://example.com">the site</a></p><script>evil()</script>`,
+	}
+	if err := episode.Download(podcast.Path, ioutil.Discard, ""); err != nil {
+		t.Fatalf("Expected to be able to download, but got: %#v", err)
+	}
+
+	table := []struct {
+		format   string
+		expected string
+	}{
+		{"md", "# Episode <1>\n\nWed, 21 Dec 2016 16:01:07 +0000\n\nSee [the site](https://example.com)\n"},
+		{"txt", "Episode <1>\nWed, 21 Dec 2016 16:01:07 +0000\n\nSee the site (https://example.com)\n"},
+		{"html", `<p>See <a href="https://example.com">the site</a></p>`},
+	}
+
+	for _, e := range table {
+		podcast.ShowNotes = e.format
+		if err := podcast.WriteShowNotes(episode); err != nil {
+			t.Fatalf("Expected no error, but got: %#v", err)
+		}
+
+		content, _ := os.ReadFile(filepath.Join(podcast.Path, "episode."+e.format))
+		if !strings.Contains(string(content), e.expected) || strings.Contains(string(content), "evil") {
+			t.Errorf("Expected %s notes to contain %#v, but got: %#v", e.format, e.expected, string(content))
+		}
+	}
+
+	podcast.ShowNotes = "pdf"
+	if err := podcast.WriteShowNotes(episode); err != ErrInvalidShowNotesFormat {
+		t.Errorf("Expected %#v, but got: %#v", ErrInvalidShowNotesFormat, err)
+	}
+}
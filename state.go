@@ -0,0 +1,203 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateFile = ".state"
+
+// State keeps track of what pcd did in a podcast directory. It is stored next
+// to the .feed cache and, unlike the cache, survives a sync.
+type State struct {
+	Downloads []Download
+
+	// Images are the cover and episode artwork fetched by pcd.
+	Images []CachedImage
+	// CoverURL is the podcast's artwork as of the last sync. Episodes
+	// that use it have no artwork of their own.
+	CoverURL string
+}
+
+// Download is the record of an episode file written by pcd.
+type Download struct {
+	GUID     string
+	URL      string
+	Title    string
+	Date     string
+	Filename string
+	Size     int64
+	Time     time.Time
+	// Image is the file name of the episode's artwork, if pcd fetched it.
+	Image string
+	// Notes is the file name of the show notes pcd wrote for the episode.
+	Notes string
+	// Transcript and Chapters are the file names of the transcript and
+	// chapters pcd downloaded for the episode.
+	Transcript string
+	Chapters   string
+
+	// Pruned is set once the files were removed by the retention policy.
+	Pruned bool
+
+	// PreviousURLs are the enclosure urls the episode had before the feed
+	// moved it, oldest first.
+	PreviousURLs []string
+}
+
+// LoadState reads the state of the podcast directory in path. A directory
+// without state yields an empty State.
+func LoadState(path string) (*State, error) {
+	f, err := os.Open(filepath.Join(path, stateFile))
+	if os.IsNotExist(err) {
+		return &State{}, nil
+	}
+	if err != nil {
+		log.Printf("Could not open state file: %#v", err)
+		return nil, ErrFilesystemError
+	}
+	defer f.Close()
+
+	var state State
+	if err := decodeGOB64(f, &state); err != nil {
+		log.Printf("Could not decode state: %#v", err)
+		return nil, ErrCouldNotReadFromCache
+	}
+
+	return &state, nil
+}
+
+// Save writes the state to the podcast directory in path.
+func (s *State) Save(path string) error {
+	blob, err := toGOB64(s)
+	if err != nil {
+		log.Print(err)
+		return ErrEncodeError
+	}
+
+	f, err := os.Create(filepath.Join(path, stateFile))
+	if err != nil {
+		log.Print(err)
+		return ErrFilesystemError
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, blob); err != nil {
+		log.Print(err)
+		return ErrFilesystemError
+	}
+
+	return nil
+}
+
+// Find returns the download record of the episode, or nil if pcd never
+// downloaded it.
+func (s *State) Find(episode *Episode) *Download {
+	key := episode.Key()
+	for i := range s.Downloads {
+		if s.Downloads[i].key() == key {
+			return &s.Downloads[i]
+		}
+	}
+	return nil
+}
+
+// DownloadedFile returns the path of the file the episode was downloaded to,
+// or an empty string when pcd has no record of downloading it.
+func (p *Podcast) DownloadedFile(episode *Episode) string {
+	state, err := LoadState(p.Path)
+	if err != nil {
+		return ""
+	}
+
+	download := state.Find(episode)
+	if download == nil || download.Pruned {
+		return ""
+	}
+
+	return filepath.Join(p.Path, download.Filename)
+}
+
+func (s *State) add(d Download) {
+	for i := range s.Downloads {
+		if s.Downloads[i].key() == d.key() {
+			s.Downloads[i] = d
+			return
+		}
+	}
+	s.Downloads = append(s.Downloads, d)
+}
+
+func (d *Download) key() string {
+	if d.GUID != "" {
+		return d.GUID
+	}
+	return d.URL
+}
+
+// recordDownload records the download of the episode to filename. The
+// record of an earlier download of the episode is updated, and its file
+// removed when it had another name.
+func recordDownload(path string, episode *Episode, filename string, size int64) error {
+	state, err := LoadState(path)
+	if err != nil {
+		return err
+	}
+
+	download := Download{}
+	if previous := state.Find(episode); previous != nil {
+		if !previous.Pruned && previous.Filename != filename {
+			if err := os.Remove(filepath.Join(path, previous.Filename)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Could not remove the earlier download: %#v", err)
+			}
+		}
+		// the artwork, notes and such stay as they are
+		download = *previous
+		download.Pruned = false
+	}
+	download.GUID = episode.GUID
+	download.URL = episode.URL
+	download.Title = episode.Title
+	download.Date = episode.Date
+	download.Filename = filename
+	download.Size = size
+	download.Time = time.Now()
+	state.add(download)
+
+	return state.Save(path)
+}
+
+// updateDownloadSize sets the size of the recorded download of the episode,
+// for files pcd changed after downloading them.
+func updateDownloadSize(path string, episode *Episode, size int64) error {
+	state, err := LoadState(path)
+	if err != nil {
+		return err
+	}
+
+	download := state.Find(episode)
+	if download == nil {
+		return ErrNotDownloaded
+	}
+	download.Size = size
+
+	return state.Save(path)
+}
@@ -0,0 +1,259 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"strconv"
+	"unicode/utf16"
+)
+
+// ID3v2.3 is written rather than 2.4, as plenty of car stereos and players
+// still don't understand the latter.
+const (
+	id3HeaderSize = 10
+	id3Padding    = 1024
+)
+
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+// id3v24Only are the frames that have no equivalent in ID3v2.3.
+var id3v24Only = map[string]bool{
+	"ASPI": true, "EQU2": true, "RVA2": true, "SEEK": true, "SIGN": true,
+	"TDEN": true, "TDOR": true, "TDRC": true, "TDRL": true, "TDTG": true,
+	"TIPL": true, "TMCL": true, "TMOO": true, "TPRO": true, "TSOA": true,
+	"TSOP": true, "TSOT": true, "TSST": true,
+}
+
+// writeID3 writes src to dst with a new ID3v2.3 tag holding the metadata
+// and the frames of the existing tag that we can carry over.
+func writeID3(dst io.Writer, src io.ReaderAt, size int64, m *Metadata) error {
+	existing, tagSize, err := readID3(src, size)
+	if err != nil {
+		return err
+	}
+
+	replaced := make(map[string]bool)
+	var frames []id3Frame
+
+	if m.Album != "" {
+		frames = append(frames, textFrame("TALB", m.Album))
+	}
+	if m.Title != "" {
+		frames = append(frames, textFrame("TIT2", m.Title))
+	}
+	if !m.Date.IsZero() {
+		frames = append(frames,
+			textFrame("TYER", m.Date.Format("2006")),
+			textFrame("TDAT", m.Date.Format("0201")))
+	}
+	if m.Track > 0 {
+		frames = append(frames, textFrame("TRCK", strconv.Itoa(m.Track)))
+	}
+	if len(m.Cover) > 0 {
+		frames = append(frames, pictureFrame(m.CoverMIME, m.Cover))
+	}
+	for _, frame := range frames {
+		replaced[frame.id] = true
+	}
+
+	for _, frame := range existing {
+		if !replaced[frame.id] {
+			frames = append(frames, frame)
+		}
+	}
+
+	var body bytes.Buffer
+	for _, frame := range frames {
+		body.WriteString(frame.id)
+		binary.Write(&body, binary.BigEndian, uint32(len(frame.data)))
+		body.Write(frame.flags[:])
+		body.Write(frame.data)
+	}
+	body.Write(make([]byte, id3Padding))
+
+	header := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 0}
+	putSynchsafe(header[6:], uint32(body.Len()))
+
+	if _, err := dst.Write(header); err != nil {
+		log.Printf("Could not write tag: %#v", err)
+		return ErrCouldNotWriteFile
+	}
+	if _, err := dst.Write(body.Bytes()); err != nil {
+		log.Printf("Could not write tag: %#v", err)
+		return ErrCouldNotWriteFile
+	}
+	if _, err := io.Copy(dst, io.NewSectionReader(src, tagSize, size-tagSize)); err != nil {
+		log.Printf("Could not copy audio: %#v", err)
+		return ErrCouldNotWriteFile
+	}
+
+	return nil
+}
+
+// readID3 returns the frames of the ID3v2 tag at the start of r that can be
+// written as ID3v2.3, and the number of bytes the tag takes. Tags we can't
+// make sense of are dropped entirely.
+func readID3(r io.ReaderAt, size int64) ([]id3Frame, int64, error) {
+	header := make([]byte, id3HeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil || !bytes.HasPrefix(header, []byte("ID3")) {
+		return nil, 0, nil
+	}
+
+	version, flags := header[3], header[5]
+	tagSize := int64(id3HeaderSize) + int64(synchsafe(header[6:]))
+	if version == 4 && flags&0x10 != 0 {
+		// footer
+		tagSize += id3HeaderSize
+	}
+	if tagSize > size {
+		return nil, 0, ErrCouldNotParseFile
+	}
+
+	// unsynchronised tags and versions other than 2.3 and 2.4 are
+	// replaced without carrying anything over
+	if (version != 3 && version != 4) || flags&0x80 != 0 {
+		return nil, tagSize, nil
+	}
+
+	body := make([]byte, synchsafe(header[6:]))
+	if _, err := r.ReadAt(body, id3HeaderSize); err != nil {
+		log.Printf("Could not read tag: %#v", err)
+		return nil, 0, ErrCouldNotReadFile
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && len(body) >= 4 {
+		// extended header, its size excludes itself in 2.3
+		if version == 3 {
+			pos = 4 + int(binary.BigEndian.Uint32(body))
+		} else {
+			pos = int(synchsafe(body))
+		}
+	}
+
+	var frames []id3Frame
+	for pos+10 <= len(body) && body[pos] != 0 {
+		var frameSize int
+		if version == 3 {
+			frameSize = int(binary.BigEndian.Uint32(body[pos+4:]))
+		} else {
+			frameSize = int(synchsafe(body[pos+4:]))
+		}
+		if frameSize < 0 || pos+10+frameSize > len(body) {
+			break
+		}
+
+		frame := id3Frame{
+			id:   string(body[pos : pos+4]),
+			data: body[pos+10 : pos+10+frameSize],
+		}
+		statusFlags, formatFlags := body[pos+8], body[pos+9]
+		pos += 10 + frameSize
+
+		if version == 3 {
+			// compressed, encrypted or grouped frames are dropped
+			if formatFlags&0xe0 != 0 {
+				continue
+			}
+			frame.flags[0] = statusFlags
+		} else if !convertibleFromV24(&frame, formatFlags) {
+			continue
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames, tagSize, nil
+}
+
+// convertibleFromV24 reports whether an ID3v2.4 frame can be written as is in
+// an ID3v2.3 tag.
+func convertibleFromV24(frame *id3Frame, formatFlags byte) bool {
+	if formatFlags != 0 || id3v24Only[frame.id] || len(frame.data) == 0 {
+		return false
+	}
+
+	switch {
+	case frame.id[0] == 'T', frame.id == "COMM", frame.id == "USLT", frame.id == "APIC", frame.id == "WXXX":
+		// UTF-16BE without BOM and UTF-8 are new in 2.4
+		return frame.data[0] < 2
+	}
+
+	return true
+}
+
+func textFrame(id, text string) id3Frame {
+	return id3Frame{id: id, data: encodeText(text)}
+}
+
+// pictureFrame returns an APIC frame with the front cover.
+func pictureFrame(mime string, picture []byte) id3Frame {
+	var data bytes.Buffer
+	data.WriteByte(0) // latin1 description
+	data.WriteString(mime)
+	data.WriteByte(0)
+	data.WriteByte(3) // front cover
+	data.WriteByte(0) // empty description
+	data.Write(picture)
+
+	return id3Frame{id: "APIC", data: data.Bytes()}
+}
+
+// encodeText encodes s as ISO-8859-1 when possible and as UTF-16 with a byte
+// order mark otherwise, prefixed with the encoding byte.
+func encodeText(s string) []byte {
+	latin1 := true
+	for _, r := range s {
+		if r > 0xff {
+			latin1 = false
+			break
+		}
+	}
+
+	var b bytes.Buffer
+	if latin1 {
+		b.WriteByte(0)
+		for _, r := range s {
+			b.WriteByte(byte(r))
+		}
+		return b.Bytes()
+	}
+
+	b.Write([]byte{1, 0xff, 0xfe})
+	for _, u := range utf16.Encode([]rune(s)) {
+		binary.Write(&b, binary.LittleEndian, u)
+	}
+	return b.Bytes()
+}
+
+func synchsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+func putSynchsafe(b []byte, n uint32) {
+	b[0] = byte(n>>21) & 0x7f
+	b[1] = byte(n>>14) & 0x7f
+	b[2] = byte(n>>7) & 0x7f
+	b[3] = byte(n) & 0x7f
+}
@@ -0,0 +1,330 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+)
+
+// MP4 metadata lives in moov/udta/meta/ilst. Rewriting it changes the size
+// of the moov box, so when moov comes before the media data the chunk
+// offsets in stco/co64 have to be moved along.
+
+// containers are the boxes on the way to ilst and to the chunk offset
+// tables. Everything else is kept as opaque bytes.
+var containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "meta": true, "ilst": true,
+}
+
+// Data types of the iTunes metadata data atom
+const (
+	dataImplicit = 0
+	dataUTF8     = 1
+	dataJPEG     = 13
+	dataPNG      = 14
+)
+
+type box struct {
+	typ string
+	// prefix holds the version and flags of full boxes like meta
+	prefix []byte
+	// data is the payload of boxes that aren't parsed any further
+	data     []byte
+	children []*box
+}
+
+type boxHeader struct {
+	typ        string
+	offset     int64
+	headerSize int64
+	size       int64
+}
+
+// writeMP4 writes src to dst with the metadata added to the moov box.
+func writeMP4(dst io.Writer, src io.ReaderAt, size int64, m *Metadata) error {
+	headers, err := readBoxHeaders(src, 0, size)
+	if err != nil {
+		return err
+	}
+
+	var moovHeader *boxHeader
+	for i := range headers {
+		if headers[i].typ == "moov" {
+			moovHeader = &headers[i]
+			break
+		}
+	}
+	if moovHeader == nil {
+		return ErrCouldNotParseFile
+	}
+
+	raw := make([]byte, moovHeader.size-moovHeader.headerSize)
+	if _, err := src.ReadAt(raw, moovHeader.offset+moovHeader.headerSize); err != nil {
+		log.Printf("Could not read moov box: %#v", err)
+		return ErrCouldNotReadFile
+	}
+	moov := &box{typ: "moov"}
+	if moov.children, err = parseBoxes(raw, "moov"); err != nil {
+		return err
+	}
+
+	setMetadata(moov, m)
+
+	var encoded bytes.Buffer
+	moov.encode(&encoded)
+
+	// everything after the old moov box moves by delta
+	delta := int64(encoded.Len()) - moovHeader.size
+	moovEnd := moovHeader.offset + moovHeader.size
+	if delta != 0 {
+		moov.shiftChunkOffsets(moovEnd, delta)
+		encoded.Reset()
+		moov.encode(&encoded)
+	}
+
+	for _, h := range headers {
+		if h.typ == "moov" {
+			if _, err := dst.Write(encoded.Bytes()); err != nil {
+				log.Printf("Could not write moov box: %#v", err)
+				return ErrCouldNotWriteFile
+			}
+			continue
+		}
+		if _, err := io.Copy(dst, io.NewSectionReader(src, h.offset, h.size)); err != nil {
+			log.Printf("Could not copy %s box: %#v", h.typ, err)
+			return ErrCouldNotWriteFile
+		}
+	}
+
+	return nil
+}
+
+// readBoxHeaders lists the top level boxes of the file.
+func readBoxHeaders(r io.ReaderAt, offset, end int64) ([]boxHeader, error) {
+	var headers []boxHeader
+
+	buf := make([]byte, 16)
+	for offset < end {
+		if _, err := r.ReadAt(buf[:8], offset); err != nil {
+			return nil, ErrCouldNotParseFile
+		}
+
+		h := boxHeader{
+			typ:        string(buf[4:8]),
+			offset:     offset,
+			headerSize: 8,
+			size:       int64(binary.BigEndian.Uint32(buf)),
+		}
+		switch h.size {
+		case 0:
+			// extends to the end of the file
+			h.size = end - offset
+		case 1:
+			if _, err := r.ReadAt(buf[8:16], offset+8); err != nil {
+				return nil, ErrCouldNotParseFile
+			}
+			h.headerSize = 16
+			h.size = int64(binary.BigEndian.Uint64(buf[8:16]))
+		}
+		if h.size < h.headerSize || offset+h.size > end {
+			return nil, ErrCouldNotParseFile
+		}
+
+		headers = append(headers, h)
+		offset += h.size
+	}
+
+	return headers, nil
+}
+
+// parseBoxes parses the boxes in b, descending into containers. The items of
+// an ilst are containers of data atoms.
+func parseBoxes(b []byte, parent string) ([]*box, error) {
+	var boxes []*box
+
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, ErrCouldNotParseFile
+		}
+		size := int64(binary.BigEndian.Uint32(b))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = int64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, ErrCouldNotParseFile
+			}
+			size = int64(binary.BigEndian.Uint64(b[8:]))
+			headerSize = 16
+		}
+		if size < headerSize || size > int64(len(b)) {
+			return nil, ErrCouldNotParseFile
+		}
+
+		bx := &box{typ: string(b[4:8])}
+		payload := b[headerSize:size]
+
+		if containers[bx.typ] || parent == "ilst" {
+			if bx.typ == "meta" && isFullBox(payload) {
+				bx.prefix, payload = payload[:4], payload[4:]
+			}
+			children, err := parseBoxes(payload, bx.typ)
+			if err != nil {
+				return nil, err
+			}
+			bx.children = children
+		} else {
+			bx.data = payload
+		}
+
+		boxes = append(boxes, bx)
+		b = b[size:]
+	}
+
+	return boxes, nil
+}
+
+// isFullBox tells the iTunes meta box, which has a version and flags, apart
+// from the QuickTime one which hasn't.
+func isFullBox(payload []byte) bool {
+	return len(payload) >= 12 && string(payload[4:8]) != "hdlr"
+}
+
+func (b *box) encode(w *bytes.Buffer) {
+	var payload bytes.Buffer
+	payload.Write(b.prefix)
+	if b.children != nil {
+		for _, child := range b.children {
+			child.encode(&payload)
+		}
+	} else {
+		payload.Write(b.data)
+	}
+
+	binary.Write(w, binary.BigEndian, uint32(payload.Len()+8))
+	w.WriteString(b.typ)
+	w.Write(payload.Bytes())
+}
+
+func (b *box) child(typ string) *box {
+	for _, child := range b.children {
+		if child.typ == typ {
+			return child
+		}
+	}
+	return nil
+}
+
+// childOrNew returns the child of type typ, adding it when missing.
+func (b *box) childOrNew(typ string, create func() *box) *box {
+	if child := b.child(typ); child != nil {
+		return child
+	}
+	child := create()
+	b.children = append(b.children, child)
+	return child
+}
+
+// set replaces the child of type typ, or adds it when missing.
+func (b *box) set(child *box) {
+	for i := range b.children {
+		if b.children[i].typ == child.typ {
+			b.children[i] = child
+			return
+		}
+	}
+	b.children = append(b.children, child)
+}
+
+func setMetadata(moov *box, m *Metadata) {
+	udta := moov.childOrNew("udta", func() *box {
+		return &box{typ: "udta", children: []*box{}}
+	})
+	meta := udta.childOrNew("meta", func() *box {
+		hdlr := &box{typ: "hdlr", data: append(make([]byte, 8), []byte("mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00")...)}
+		return &box{typ: "meta", prefix: make([]byte, 4), children: []*box{hdlr}}
+	})
+	ilst := meta.childOrNew("ilst", func() *box {
+		return &box{typ: "ilst", children: []*box{}}
+	})
+
+	if m.Album != "" {
+		ilst.set(item("\xa9alb", dataUTF8, []byte(m.Album)))
+	}
+	if m.Title != "" {
+		ilst.set(item("\xa9nam", dataUTF8, []byte(m.Title)))
+	}
+	if !m.Date.IsZero() {
+		ilst.set(item("\xa9day", dataUTF8, []byte(m.Date.UTC().Format("2006-01-02T15:04:05Z"))))
+	}
+	if m.Track > 0 {
+		track := make([]byte, 8)
+		binary.BigEndian.PutUint16(track[2:], uint16(m.Track))
+		ilst.set(item("trkn", dataImplicit, track))
+	}
+	if len(m.Cover) > 0 {
+		typ := uint32(dataJPEG)
+		if m.CoverMIME == "image/png" {
+			typ = dataPNG
+		}
+		ilst.set(item("covr", typ, m.Cover))
+	}
+}
+
+// item returns an ilst item holding a single data atom.
+func item(typ string, dataType uint32, value []byte) *box {
+	data := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(data, dataType)
+	data = append(data, value...)
+
+	return &box{typ: typ, children: []*box{{typ: "data", data: data}}}
+}
+
+// shiftChunkOffsets moves the chunk offsets that point past from by delta.
+func (b *box) shiftChunkOffsets(from, delta int64) {
+	for _, child := range b.children {
+		switch child.typ {
+		case "stco":
+			// version, flags and entry count precede the entries
+			if len(child.data) < 8 {
+				continue
+			}
+			for i := 8; i+4 <= len(child.data); i += 4 {
+				offset := int64(binary.BigEndian.Uint32(child.data[i:]))
+				if offset >= from {
+					binary.BigEndian.PutUint32(child.data[i:], uint32(offset+delta))
+				}
+			}
+		case "co64":
+			if len(child.data) < 8 {
+				continue
+			}
+			for i := 8; i+8 <= len(child.data); i += 8 {
+				offset := int64(binary.BigEndian.Uint64(child.data[i:]))
+				if offset >= from {
+					binary.BigEndian.PutUint64(child.data[i:], uint64(offset+delta))
+				}
+			}
+		default:
+			child.shiftChunkOffsets(from, delta)
+		}
+	}
+}
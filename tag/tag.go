@@ -0,0 +1,143 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package tag writes podcast metadata into audio files: ID3v2 tags for MP3
+// and iTunes style atoms for MP4/M4A.
+package tag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Metadata is written into a file by WriteFile. Empty fields are left
+// untouched.
+type Metadata struct {
+	Album string
+	Title string
+	Date  time.Time
+	Track int
+
+	Cover []byte
+	// CoverMIME is the type of Cover, image/jpeg or image/png.
+	CoverMIME string
+}
+
+var (
+	ErrUnsupportedFormat  = errors.New("Unsupported audio format")
+	ErrCouldNotReadFile   = errors.New("Could not read audio file")
+	ErrCouldNotWriteFile  = errors.New("Could not write audio file")
+	ErrCouldNotParseFile  = errors.New("Could not parse audio file")
+	ErrUnsupportedPicture = errors.New("Unsupported cover art, only JPEG and PNG are supported")
+)
+
+type format int
+
+const (
+	formatUnknown format = iota
+	formatMP3
+	formatMP4
+)
+
+// WriteFile writes the metadata into the audio file at path. The file is
+// rewritten through a temporary file, so it is never left half tagged.
+func WriteFile(path string, m *Metadata) error {
+	if len(m.Cover) > 0 && m.CoverMIME != "image/jpeg" && m.CoverMIME != "image/png" {
+		return ErrUnsupportedPicture
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		log.Printf("Could not open file: %#v", err)
+		return ErrCouldNotReadFile
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		log.Printf("Could not stat file: %#v", err)
+		return ErrCouldNotReadFile
+	}
+
+	var write func(dst io.Writer, src io.ReaderAt, size int64, m *Metadata) error
+	switch detect(src, path) {
+	case formatMP3:
+		write = writeID3
+	case formatMP4:
+		write = writeMP4
+	default:
+		return ErrUnsupportedFormat
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tag-*")
+	if err != nil {
+		log.Printf("Could not create temporary file: %#v", err)
+		return ErrCouldNotWriteFile
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp, src, info.Size(), m); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		log.Printf("Could not write temporary file: %#v", err)
+		return ErrCouldNotWriteFile
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		log.Printf("Could not set file mode: %#v", err)
+		return ErrCouldNotWriteFile
+	}
+
+	src.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		log.Printf("Could not replace file: %#v", err)
+		return ErrCouldNotWriteFile
+	}
+
+	return nil
+}
+
+// detect looks at the first bytes of the file to find out its format and
+// falls back to the extension.
+func detect(r io.ReaderAt, path string) format {
+	header := make([]byte, 12)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return formatMP3
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		return formatMP4
+	case len(header) >= 2 && header[0] == 0xff && header[1]&0xe0 == 0xe0:
+		return formatMP3
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		return formatMP3
+	case ".m4a", ".m4b", ".mp4", ".m4v":
+		return formatMP4
+	}
+
+	return formatUnknown
+}
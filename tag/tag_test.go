@@ -0,0 +1,201 @@
+package tag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var metadata = &Metadata{
+	Album:     "Title of Podcast",
+	Title:     "Épisode ☃",
+	Date:      time.Date(2016, 12, 21, 16, 1, 7, 0, time.UTC),
+	Track:     12,
+	Cover:     []byte("\xff\xd8\xff\xe0 not really a jpeg"),
+	CoverMIME: "image/jpeg",
+}
+
+func writeTemp(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func rawBox(typ string, payload ...[]byte) []byte {
+	content := bytes.Join(payload, nil)
+	b := make([]byte, 8, 8+len(content))
+	binary.BigEndian.PutUint32(b, uint32(8+len(content)))
+	copy(b[4:], typ)
+	return append(b, content...)
+}
+
+func id3v23(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	header := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 0}
+	putSynchsafe(header[6:], uint32(len(body)))
+	return append(header, body...)
+}
+
+func rawFrame(id string, data []byte) []byte {
+	b := make([]byte, 10, 10+len(data))
+	copy(b, id)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(data)))
+	return append(b, data...)
+}
+
+func TestWriteID3(t *testing.T) {
+	audio := []byte{0xff, 0xfb, 0x90, 0x64, 1, 2, 3, 4}
+	existing := id3v23(
+		rawFrame("TPE1", encodeText("Author Name")),
+		rawFrame("TIT2", encodeText("Publisher title")),
+	)
+
+	table := []struct {
+		name    string
+		content []byte
+	}{
+		{"without a tag", audio},
+		{"with an existing tag", append(existing, audio...)},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			path := writeTemp(t, "episode.mp3", e.content)
+
+			if err := WriteFile(path, metadata); err != nil {
+				t.Fatalf("Expected no error, but got: %#v", err)
+			}
+
+			content, _ := os.ReadFile(path)
+			if !bytes.HasSuffix(content, audio) {
+				t.Errorf("Expected the audio to be kept intact")
+			}
+
+			frames, size, err := readID3(bytes.NewReader(content), int64(len(content)))
+			if err != nil {
+				t.Fatalf("Expected to be able to read the tag, but got: %#v", err)
+			}
+			if size != int64(len(content)-len(audio)) {
+				t.Errorf("Expected a tag of %d bytes, but got %d", len(content)-len(audio), size)
+			}
+
+			got := make(map[string][]byte)
+			for _, frame := range frames {
+				if _, ok := got[frame.id]; ok {
+					t.Errorf("Expected a single %s frame", frame.id)
+				}
+				got[frame.id] = frame.data
+			}
+
+			want := map[string][]byte{
+				"TALB": encodeText("Title of Podcast"),
+				"TIT2": encodeText("Épisode ☃"),
+				"TYER": encodeText("2016"),
+				"TDAT": encodeText("2112"),
+				"TRCK": encodeText("12"),
+				"APIC": pictureFrame("image/jpeg", metadata.Cover).data,
+			}
+			if len(e.content) > len(audio) {
+				want["TPE1"] = encodeText("Author Name")
+			}
+			if len(got) != len(want) {
+				t.Errorf("Expected %d frames, but got %d", len(want), len(got))
+			}
+			for id, data := range want {
+				if !bytes.Equal(got[id], data) {
+					t.Errorf("Expected %s to be %q, but got %q", id, data, got[id])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteMP4(t *testing.T) {
+	mdatPayload := []byte("sample data")
+	ftyp := rawBox("ftyp", []byte("M4A \x00\x00\x00\x00"))
+
+	// the chunk offset points at the start of the mdat payload, which
+	// comes right after ftyp, moov and the mdat header
+	stco := func(offset uint32) []byte {
+		data := make([]byte, 12)
+		binary.BigEndian.PutUint32(data[4:], 1)
+		binary.BigEndian.PutUint32(data[8:], offset)
+		return rawBox("stco", data)
+	}
+	moovSize := len(rawBox("moov", rawBox("trak", rawBox("mdia", rawBox("minf", rawBox("stbl", stco(0)))))))
+	offset := uint32(len(ftyp) + moovSize + 8)
+	moov := rawBox("moov", rawBox("trak", rawBox("mdia", rawBox("minf", rawBox("stbl", stco(offset))))))
+
+	content := bytes.Join([][]byte{ftyp, moov, rawBox("mdat", mdatPayload)}, nil)
+	path := writeTemp(t, "episode.m4a", content)
+
+	if err := WriteFile(path, metadata); err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+
+	content, _ = os.ReadFile(path)
+	headers, err := readBoxHeaders(bytes.NewReader(content), 0, int64(len(content)))
+	if err != nil || len(headers) != 3 {
+		t.Fatalf("Expected 3 top level boxes, but got %d (%#v)", len(headers), err)
+	}
+
+	moovBox := &box{typ: "moov"}
+	if moovBox.children, err = parseBoxes(content[headers[1].offset+8:headers[1].offset+headers[1].size], "moov"); err != nil {
+		t.Fatalf("Expected to be able to parse moov, but got: %#v", err)
+	}
+
+	chunk := moovBox.child("trak").child("mdia").child("minf").child("stbl").child("stco")
+	newOffset := binary.BigEndian.Uint32(chunk.data[8:])
+	if !bytes.HasPrefix(content[newOffset:], mdatPayload) {
+		t.Errorf("Expected the chunk offset to still point at the sample data")
+	}
+
+	ilst := moovBox.child("udta").child("meta").child("ilst")
+	if ilst == nil {
+		t.Fatal("Expected moov/udta/meta/ilst to be created")
+	}
+
+	want := map[string][]byte{
+		"\xa9alb": []byte("Title of Podcast"),
+		"\xa9nam": []byte("Épisode ☃"),
+		"\xa9day": []byte("2016-12-21T16:01:07Z"),
+		"trkn":    {0, 0, 0, 12, 0, 0, 0, 0},
+		"covr":    metadata.Cover,
+	}
+	for typ, value := range want {
+		item := ilst.child(typ)
+		if item == nil {
+			t.Errorf("Expected a %q item", typ)
+			continue
+		}
+		if got := item.child("data").data[8:]; !bytes.Equal(got, value) {
+			t.Errorf("Expected %q to be %q, but got %q", typ, value, got)
+		}
+	}
+
+	// tagging again replaces the items instead of adding new ones
+	if err := WriteFile(path, &Metadata{Title: "New title"}); err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+	again, _ := os.ReadFile(path)
+	if bytes.Count(again, []byte("\xa9nam")) != 1 || !bytes.Contains(again, []byte("New title")) {
+		t.Errorf("Expected the title to be replaced")
+	}
+}
+
+func TestWriteUnsupported(t *testing.T) {
+	path := writeTemp(t, "episode.ogg", []byte("OggS"))
+	if err := WriteFile(path, metadata); err != ErrUnsupportedFormat {
+		t.Errorf("Expected %#v, but got %#v", ErrUnsupportedFormat, err)
+	}
+
+	path = writeTemp(t, "episode.mp3", []byte{0xff, 0xfb})
+	if err := WriteFile(path, &Metadata{Cover: []byte("GIF89a"), CoverMIME: "image/gif"}); err != ErrUnsupportedPicture {
+		t.Errorf("Expected %#v, but got %#v", ErrUnsupportedPicture, err)
+	}
+}
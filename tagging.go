@@ -0,0 +1,99 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/kvannotten/pcd/tag"
+	"github.com/pkg/errors"
+)
+
+var ErrNotDownloaded = errors.New("Episode has not been downloaded")
+
+// TagEpisode writes the podcast name, the episode's title, date, number and
+// artwork into the downloaded file of the episode. Artwork that can't be
+// fetched is left out rather than failing the whole tag. The size of the
+// download is updated to the one of the tagged file.
+func (p *Podcast) TagEpisode(e *Episode) error {
+	file := p.DownloadedFile(e)
+	if file == "" {
+		return ErrNotDownloaded
+	}
+
+	m := &tag.Metadata{
+		Album: p.Name,
+		Title: e.Title,
+		Date:  e.PubDate(),
+		Track: e.Number,
+	}
+	if e.Image != "" {
+		m.Cover, m.CoverMIME = fetchCover(e.Image)
+	}
+
+	if err := tag.WriteFile(file, m); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		log.Printf("Could not stat tagged file: %#v", err)
+		return ErrFilesystemError
+	}
+	return updateDownloadSize(p.Path, e, info.Size())
+}
+
+// fetchCover downloads the image at url, returning nothing when it isn't a
+// JPEG or PNG.
+func fetchCover(url string) ([]byte, string) {
+	resp, err := imageClient.Get(url)
+	if err != nil {
+		log.Printf("Could not download cover: %#v", err)
+		return nil, ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Could not download cover: %s", resp.Status)
+		return nil, ""
+	}
+
+	cover, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		log.Printf("Could not download cover: %#v", err)
+		return nil, ""
+	}
+	if len(cover) > maxImageSize {
+		log.Printf("Cover %s is too large, leaving it out", url)
+		return nil, ""
+	}
+
+	// servers often send images as application/octet-stream, so sniff
+	// the content instead of trusting the header
+	mime := http.DetectContentType(cover)
+	if i := strings.Index(mime, ";"); i >= 0 {
+		mime = mime[:i]
+	}
+	if mime != "image/jpeg" && mime != "image/png" {
+		log.Printf("Cover %s is %s, only JPEG and PNG can be embedded", url, mime)
+		return nil, ""
+	}
+
+	return cover, mime
+}
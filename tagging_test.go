@@ -0,0 +1,68 @@
+package pcd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestTagEpisode(t *testing.T) {
+	audio := []byte{0xff, 0xfb, 0x90, 0x64, 1, 2, 3, 4}
+	cover := []byte("\x89PNG\r\n\x1a\n not really a png")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cover":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(cover)
+		default:
+			w.Write(audio)
+		}
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "Title of Podcast", Path: randomPath(t)}
+	defer os.RemoveAll(podcast.Path)
+
+	episode := &Episode{
+		Title:  "Episode title",
+		Date:   "Wed, 21 Dec 2016 16:01:07 +0000",
+		URL:    ts.URL + "/episode.mp3",
+		Number: 3,
+		Image:  ts.URL + "/cover",
+	}
+
+	if err := podcast.TagEpisode(episode); err != ErrNotDownloaded {
+		t.Errorf("Expected %#v, but got: %#v", ErrNotDownloaded, err)
+	}
+
+	if err := episode.Download(podcast.Path, ioutil.Discard, ""); err != nil {
+		t.Fatalf("Expected to be able to download, but got: %#v", err)
+	}
+	if err := podcast.TagEpisode(episode); err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+
+	content, err := os.ReadFile(podcast.DownloadedFile(episode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(podcast.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := state.Find(episode).Size; size != int64(len(content)) {
+		t.Errorf("Expected %#v, but got: %#v", int64(len(content)), size)
+	}
+	if !bytes.HasPrefix(content, []byte("ID3")) || !bytes.HasSuffix(content, audio) {
+		t.Errorf("Expected an ID3 tag in front of the audio")
+	}
+	for _, expected := range [][]byte{[]byte("Title of Podcast"), []byte("Episode title"), []byte("image/png"), cover} {
+		if !bytes.Contains(content, expected) {
+			t.Errorf("Expected the tag to contain %q", expected)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pcd
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxSidecarSize is the largest transcript or chapters file pcd downloads.
+const maxSidecarSize = 20 << 20
+
+var (
+	ErrInvalidTranscriptFormat = errors.New("Invalid transcript format, use srt, vtt, json, html or txt")
+	ErrCouldNotDownloadFile    = errors.New("Could not download file")
+)
+
+// Transcript is a transcript published for an episode.
+type Transcript struct {
+	URL      string `json:"url"`
+	Type     string `json:"type"`
+	Language string `json:"language,omitempty"`
+}
+
+// transcriptFormats maps the formats that can be configured to the MIME
+// types feeds use for them, and the extension the file is saved with.
+// Subtitles get the plain extension so players pick them up, the others
+// are marked as transcripts to not clash with the show notes.
+var transcriptFormats = map[string]struct {
+	types     []string
+	extension string
+}{
+	"srt":  {[]string{"application/x-subrip", "application/srt", "text/srt"}, ".srt"},
+	"vtt":  {[]string{"text/vtt"}, ".vtt"},
+	"json": {[]string{"application/json"}, ".transcript.json"},
+	"html": {[]string{"text/html"}, ".transcript.html"},
+	"txt":  {[]string{"text/plain"}, ".transcript.txt"},
+}
+
+// Format returns the configurable format of the transcript, or an empty
+// string for types pcd doesn't know.
+func (t *Transcript) Format() string {
+	mime := strings.ToLower(strings.TrimSpace(t.Type))
+	if i := strings.Index(mime, ";"); i >= 0 {
+		mime = strings.TrimSpace(mime[:i])
+	}
+
+	for format, f := range transcriptFormats {
+		for _, typ := range f.types {
+			if typ == mime {
+				return format
+			}
+		}
+	}
+	return ""
+}
+
+// PreferredTranscript returns the transcript of the episode in the first of
+// formats that is available, or nil.
+func (e *Episode) PreferredTranscript(formats []string) (*Transcript, error) {
+	for _, format := range formats {
+		format = strings.ToLower(format)
+		if _, ok := transcriptFormats[format]; !ok {
+			return nil, ErrInvalidTranscriptFormat
+		}
+		for i := range e.Transcripts {
+			if e.Transcripts[i].Format() == format {
+				return &e.Transcripts[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// FetchTranscript downloads the transcript of a downloaded episode in the
+// podcast's preferred format next to its audio file. Episodes without such a
+// transcript are skipped.
+func (p *Podcast) FetchTranscript(e *Episode) error {
+	transcript, err := e.PreferredTranscript(p.Transcripts)
+	if err != nil || transcript == nil {
+		return err
+	}
+
+	return p.fetchSidecar(e, transcript.URL, transcriptFormats[transcript.Format()].extension, func(d *Download, filename string) {
+		d.Transcript = filename
+	})
+}
+
+// FetchChapters downloads the chapters of a downloaded episode next to its
+// audio file.
+func (p *Podcast) FetchChapters(e *Episode) error {
+	if e.Chapters == "" {
+		return nil
+	}
+
+	return p.fetchSidecar(e, e.Chapters, ".chapters.json", func(d *Download, filename string) {
+		d.Chapters = filename
+	})
+}
+
+// fetchSidecar downloads rawurl into a file named after the episode's audio
+// file with the given extension, and records it with record.
+func (p *Podcast) fetchSidecar(e *Episode, rawurl, extension string, record func(*Download, string)) error {
+	state, err := LoadState(p.Path)
+	if err != nil {
+		return err
+	}
+	download := state.Find(e)
+	if download == nil || download.Pruned {
+		return ErrNotDownloaded
+	}
+
+	resp, err := http.Get(rawurl)
+	if err != nil {
+		log.Printf("Could not download %s: %#v", rawurl, err)
+		return ErrCouldNotDownloadFile
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Could not download %s: %s", rawurl, resp.Status)
+		return ErrCouldNotDownloadFile
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxSidecarSize+1))
+	if err != nil {
+		log.Printf("Could not download %s: %#v", rawurl, err)
+		return ErrCouldNotDownloadFile
+	}
+	if len(content) > maxSidecarSize {
+		log.Printf("%s is too large", rawurl)
+		return ErrCouldNotDownloadFile
+	}
+
+	filename := strings.TrimSuffix(download.Filename, filepath.Ext(download.Filename)) + extension
+	if err := os.WriteFile(filepath.Join(p.Path, filename), content, 0644); err != nil {
+		log.Printf("Could not write file: %#v", err)
+		return ErrFilesystemError
+	}
+
+	record(download, filename)
+	return state.Save(p.Path)
+}
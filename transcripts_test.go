@@ -0,0 +1,120 @@
+package pcd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTranscriptsAndChapters(t *testing.T) {
+	feed := strings.Replace(Podcastfeed, `<rss `, `<rss xmlns:podcast="https://podcastindex.org/namespace/1.0" `, 1)
+	feed = strings.Replace(feed, "<guid>", `<podcast:transcript url="http://example.com/1.vtt" type="text/vtt" language="en"/>
+    <podcast:transcript url="http://example.com/1.json" type="application/json"/>
+    <podcast:chapters url="http://example.com/1-chapters.json" type="application/json+chapters"/>
+    <guid>`, 1)
+
+	episodes, err := parseEpisodes(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("Expected to be able to parse, but got: %#v", err)
+	}
+
+	expected := []Transcript{
+		{URL: "http://example.com/1.vtt", Type: "text/vtt", Language: "en"},
+		{URL: "http://example.com/1.json", Type: "application/json"},
+	}
+	if len(episodes[0].Transcripts) != 2 || episodes[0].Transcripts[0] != expected[0] || episodes[0].Transcripts[1] != expected[1] {
+		t.Errorf("Expected %#v, but got: %#v", expected, episodes[0].Transcripts)
+	}
+	if episodes[0].Chapters != "http://example.com/1-chapters.json" {
+		t.Errorf("Expected the chapters url, but got: %#v", episodes[0].Chapters)
+	}
+}
+
+func TestPreferredTranscript(t *testing.T) {
+	episode := &Episode{Transcripts: []Transcript{
+		{URL: "1.json", Type: "application/json"},
+		{URL: "1.srt", Type: "application/x-subrip"},
+		{URL: "1.vtt", Type: "text/vtt; charset=utf-8"},
+	}}
+
+	table := []struct {
+		formats  []string
+		expected string
+	}{
+		{[]string{"vtt", "srt"}, "1.vtt"},
+		{[]string{"SRT"}, "1.srt"},
+		{[]string{"html", "json"}, "1.json"},
+		{[]string{"txt"}, ""},
+		{nil, ""},
+	}
+
+	for _, e := range table {
+		transcript, err := episode.PreferredTranscript(e.formats)
+		if err != nil {
+			t.Fatalf("Expected no error, but got: %#v", err)
+		}
+		got := ""
+		if transcript != nil {
+			got = transcript.URL
+		}
+		if got != e.expected {
+			t.Errorf("Expected %#v for %v, but got: %#v", e.expected, e.formats, got)
+		}
+	}
+
+	if _, err := episode.PreferredTranscript([]string{"pdf"}); err != ErrInvalidTranscriptFormat {
+		t.Errorf("Expected %#v, but got: %#v", ErrInvalidTranscriptFormat, err)
+	}
+}
+
+func TestFetchTranscriptAndChapters(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer ts.Close()
+
+	podcast := &Podcast{Name: "test", Path: randomPath(t), Transcripts: []string{"srt", "vtt"}, Chapters: true}
+	defer os.RemoveAll(podcast.Path)
+
+	episode := &Episode{
+		URL: ts.URL + "/episode.mp3",
+		Transcripts: []Transcript{
+			{URL: ts.URL + "/episode.vtt", Type: "text/vtt"},
+		},
+		Chapters: ts.URL + "/chapters.json",
+	}
+
+	if err := podcast.FetchTranscript(episode); err != ErrNotDownloaded {
+		t.Errorf("Expected %#v, but got: %#v", ErrNotDownloaded, err)
+	}
+
+	if err := episode.Download(podcast.Path, ioutil.Discard, ""); err != nil {
+		t.Fatalf("Expected to be able to download, but got: %#v", err)
+	}
+	if err := podcast.FetchTranscript(episode); err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+	if err := podcast.FetchChapters(episode); err != nil {
+		t.Fatalf("Expected no error, but got: %#v", err)
+	}
+
+	for filename, expected := range map[string]string{
+		"episode.vtt":           "content of /episode.vtt",
+		"episode.chapters.json": "content of /chapters.json",
+	} {
+		content, _ := os.ReadFile(filepath.Join(podcast.Path, filename))
+		if string(content) != expected {
+			t.Errorf("Expected %s to hold %#v, but got: %#v", filename, expected, string(content))
+		}
+	}
+
+	state, _ := LoadState(podcast.Path)
+	files := state.Find(episode).files()
+	if len(files) != 3 {
+		t.Errorf("Expected the transcript and chapters to be recorded, but got: %#v", files)
+	}
+}
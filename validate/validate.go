@@ -0,0 +1,389 @@
+// Copyright © 2018 Kristof Vannotten <kristof@vannotten.be>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package validate checks podcast feeds for the problems that make podcast
+// apps, pcd included, trip over them.
+package validate
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/kvannotten/pcd/rss"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Level is how bad a problem is. Errors break the feed, or episodes of it,
+// for some apps. Warnings are sloppy but usually work out.
+type Level string
+
+// Problem levels
+const (
+	Error   Level = "error"
+	Warning Level = "warning"
+)
+
+// Problem is a problem found in a feed.
+type Problem struct {
+	Level Level `json:"level"`
+	// Item is the position of the item in the feed, counting from 1, or zero
+	// for problems of the feed itself.
+	Item    int    `json:"item,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Item == 0 {
+		return fmt.Sprintf("%s: %s", p.Level, p.Message)
+	}
+	return fmt.Sprintf("%s: item %d %q: %s", p.Level, p.Item, p.Title, p.Message)
+}
+
+// Report lists the problems found in a feed.
+type Report struct {
+	Problems []Problem `json:"problems"`
+}
+
+// Count returns the number of problems of the level.
+func (r *Report) Count(level Level) int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Level == level {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) add(level Level, item int, title, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Level:   level,
+		Item:    item,
+		Title:   title,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Options change what is checked.
+type Options struct {
+	// ContentType is the Content-Type the feed was served with, to check
+	// its charset against the XML declaration.
+	ContentType string
+	// Client checks the media files of the items with HEAD requests. They
+	// aren't checked when it is nil.
+	Client *http.Client
+}
+
+// maxMediaChecks is the number of media files checked at once.
+const maxMediaChecks = 4
+
+// Feed checks the feed.
+func Feed(content []byte, opts Options) *Report {
+	r := &Report{}
+
+	if !checkCharset(r, content, opts.ContentType) {
+		return r
+	}
+	checkWellFormed(r, content)
+
+	d := rss.NewDecoder(bytes.NewReader(content))
+	d.ContentType = opts.ContentType
+	d.Recover = true
+
+	var items []*rss.Item
+	for {
+		item, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.add(Error, 0, "", "could not parse the feed, it is not an RSS feed or not XML")
+			return r
+		}
+		items = append(items, item)
+	}
+	for _, warning := range d.Warnings() {
+		r.add(Error, 0, "", "%s", warning)
+	}
+
+	channel := d.Channel()
+	checkChannel(r, &channel)
+	checkItems(r, items)
+	if opts.Client != nil {
+		checkMedia(r, opts.Client, items)
+	}
+
+	return r
+}
+
+var xmlEncoding = regexp.MustCompile(`^<\?xml[^>]*encoding=["']([^"']+)["']`)
+
+// utf16BOM returns the encoding of a feed starting with a UTF-16 byte order
+// mark, nil for any other feed.
+func utf16BOM(content []byte) encoding.Encoding {
+	switch {
+	case bytes.HasPrefix(content, []byte{0xfe, 0xff}):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(content, []byte{0xff, 0xfe}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	default:
+		return nil
+	}
+}
+
+// checkCharset checks the charset of the feed. It returns false when the
+// feed can't be read at all.
+func checkCharset(r *Report, content []byte, contentType string) bool {
+	// the byte order mark of a UTF-16 feed tells its charset, over the
+	// declaration and the Content-Type, like pcd reads it
+	if utf16BOM(content) != nil {
+		return true
+	}
+	content = bytes.TrimPrefix(content, []byte{0xef, 0xbb, 0xbf})
+
+	declared := ""
+	if m := xmlEncoding.FindSubmatch(content); m != nil {
+		declared = string(m[1])
+	}
+	served := ""
+	if contentType != "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			served = params["charset"]
+		}
+	}
+
+	for _, label := range []string{declared, served} {
+		if label == "" {
+			continue
+		}
+		if _, err := htmlindex.Get(label); err != nil {
+			r.add(Error, 0, "", "unknown charset %q", label)
+			return false
+		}
+	}
+	if declared != "" && served != "" && !sameCharset(declared, served) {
+		r.add(Warning, 0, "", "the feed declares charset %s but is served as %s, which takes precedence", declared, served)
+	}
+
+	charset := served
+	if charset == "" {
+		charset = declared
+	}
+	if charset == "" || sameCharset(charset, "utf-8") {
+		if line, ok := invalidUTF8(content); !ok {
+			r.add(Error, 0, "", "invalid UTF-8 on line %d, declare the charset the feed is in", line)
+			return false
+		}
+	}
+	return true
+}
+
+func sameCharset(a, b string) bool {
+	ea, err := htmlindex.Get(a)
+	if err != nil {
+		return false
+	}
+	eb, err := htmlindex.Get(b)
+	if err != nil {
+		return false
+	}
+	return ea == eb
+}
+
+// invalidUTF8 returns the line of the first invalid UTF-8 in the content.
+func invalidUTF8(content []byte) (int, bool) {
+	line := 1
+	for len(content) > 0 {
+		c, size := utf8.DecodeRune(content)
+		if c == utf8.RuneError && size == 1 {
+			return line, false
+		}
+		if c == '\n' {
+			line++
+		}
+		content = content[size:]
+	}
+	return line, true
+}
+
+// checkWellFormed reports the first XML error that pcd gets past, like HTML
+// entities and unescaped ampersands, which stricter apps don't.
+func checkWellFormed(r *Report, content []byte) {
+	var input io.Reader = bytes.NewReader(content)
+	bom := utf16BOM(content)
+	if bom != nil {
+		input = bom.NewDecoder().Reader(input)
+	}
+
+	d := xml.NewDecoder(input)
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		// the declaration is still there after converting to UTF-8
+		if bom != nil {
+			return input, nil
+		}
+		e, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return e.NewDecoder().Reader(input), nil
+	}
+
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			r.add(Warning, 0, "", "the feed is not well-formed XML: %v", err)
+			return
+		}
+	}
+}
+
+func checkChannel(r *Report, channel *rss.Channel) {
+	if strings.TrimSpace(channel.Title.Title) == "" {
+		r.add(Error, 0, "", "the feed has no title")
+	}
+	if image := channel.ImageURL(); image != "" && !absoluteURL(image) {
+		r.add(Error, 0, "", "the image url %q is not an absolute url", image)
+	}
+}
+
+func checkItems(r *Report, items []*rss.Item) {
+	guids := make(map[string]int)
+
+	for i, item := range items {
+		n, title := i+1, strings.TrimSpace(item.Title.Title)
+
+		if title == "" {
+			r.add(Error, n, title, "missing title")
+		}
+
+		media := item.Media()
+		if len(media) == 0 {
+			r.add(Error, n, title, "missing enclosure")
+		}
+		for _, m := range media {
+			if !absoluteURL(m.URL) {
+				r.add(Error, n, title, "the media url %q is not an absolute url", m.URL)
+			}
+		}
+
+		date := strings.TrimSpace(item.Date.Date)
+		switch {
+		case date == "":
+			r.add(Warning, n, title, "missing pubDate")
+		case rss.ParseDate(date).IsZero():
+			r.add(Error, n, title, "unparsable pubDate %q", date)
+		}
+
+		guid := strings.TrimSpace(item.GUID.GUID)
+		switch first, seen := guids[guid]; {
+		case guid == "":
+			r.add(Warning, n, title, "missing guid, apps will go by the enclosure url")
+		case seen:
+			r.add(Error, n, title, "duplicate guid %q of item %d", guid, first)
+		default:
+			guids[guid] = n
+		}
+
+		for _, u := range []string{item.ITunesImage.Href, item.Chapters.URL} {
+			if u != "" && !absoluteURL(u) {
+				r.add(Error, n, title, "%q is not an absolute url", u)
+			}
+		}
+		for _, transcript := range item.Transcripts {
+			if !absoluteURL(transcript.URL) {
+				r.add(Error, n, title, "the transcript url %q is not an absolute url", transcript.URL)
+			}
+		}
+	}
+}
+
+func absoluteURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// checkMedia checks that the media files of the items can be downloaded.
+func checkMedia(r *Report, client *http.Client, items []*rss.Item) {
+	problems := make([][]Problem, len(items))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan bool, maxMediaChecks)
+	for i, item := range items {
+		for _, m := range item.Media() {
+			if !absoluteURL(m.URL) {
+				continue
+			}
+
+			wg.Add(1)
+			sem <- true
+			go func(i int, title, u string) {
+				defer func() { <-sem; wg.Done() }()
+
+				if err := checkURL(client, u); err != nil {
+					p := Problem{Level: Error, Item: i + 1, Title: title, Message: fmt.Sprintf("%s: %v", u, err)}
+					mu.Lock()
+					problems[i] = append(problems[i], p)
+					mu.Unlock()
+				}
+			}(i, strings.TrimSpace(item.Title.Title), m.URL)
+		}
+	}
+	wg.Wait()
+
+	for _, p := range problems {
+		r.Problems = append(r.Problems, p...)
+	}
+}
+
+// checkURL checks the url responds with a success. Servers that don't do
+// HEAD requests get a GET of the first byte.
+func checkURL(client *http.Client, u string) error {
+	resp, err := client.Head(u)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+
+		var req *http.Request
+		if req, err = http.NewRequest("GET", u, nil); err != nil {
+			return err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		resp, err = client.Do(req)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("responds with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package validate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+func feed(items string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<rss xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" version="2.0">
+<channel>
+<title>Title of Podcast</title>
+` + items + `
+</channel>
+</rss>`
+}
+
+const goodItem = `<item>
+  <title>Good</title>
+  <guid>good</guid>
+  <pubDate>Thu, 21 Dec 2016 16:01:07 +0000</pubDate>
+  <enclosure url="http://example.com/good.mp3" length="1024" type="audio/mpeg"/>
+</item>`
+
+func utf16(content string, order unicode.Endianness) string {
+	encoded, err := unicode.UTF16(order, unicode.UseBOM).NewEncoder().String(content)
+	if err != nil {
+		panic(err)
+	}
+	return encoded
+}
+
+func problems(r *Report) string {
+	var lines []string
+	for _, p := range r.Problems {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestFeed(t *testing.T) {
+	table := []struct {
+		name        string
+		feed        string
+		contentType string
+		want        []string
+	}{
+		{"good", feed(goodItem), "", nil},
+		{"missing title", feed(strings.Replace(goodItem, "<title>Good</title>", "", 1)), "", []string{`error: item 1 "": missing title`}},
+		{"missing enclosure", feed(`<item><title>Text</title><guid>text</guid><pubDate>Thu, 21 Dec 2016 16:01:07 +0000</pubDate></item>`), "", []string{`error: item 1 "Text": missing enclosure`}},
+		{"bad date", feed(strings.Replace(goodItem, "Thu, 21 Dec 2016 16:01:07 +0000", "yesterday", 1)), "", []string{`error: item 1 "Good": unparsable pubDate "yesterday"`}},
+		{"duplicate guid", feed(goodItem + goodItem), "", []string{`error: item 2 "Good": duplicate guid "good" of item 1`}},
+		{"relative url", feed(strings.Replace(goodItem, "http://example.com/good.mp3", "/good.mp3", 1)), "", []string{`error: item 1 "Good": the media url "/good.mp3" is not an absolute url`}},
+		{"missing guid", feed(strings.Replace(goodItem, "<guid>good</guid>", "", 1)), "", []string{`warning: item 1 "Good": missing guid`}},
+		{"entities", feed(strings.Replace(goodItem, "Good", "Good&nbsp;news", 1)), "", []string{"warning: the feed is not well-formed XML"}},
+		{"invalid utf-8", feed(strings.Replace(goodItem, "Good", "Caf\xe9", 1)), "", []string{"error: invalid UTF-8 on line 6"}},
+		{"utf-16", utf16(strings.Replace(feed(goodItem), "UTF-8", "UTF-16", 1), unicode.LittleEndian), "", nil},
+		{"utf-16 big endian", utf16(strings.Replace(feed(goodItem), "UTF-8", "UTF-16", 1), unicode.BigEndian), "text/xml; charset=utf-8", nil},
+		{"charset mismatch", feed(goodItem), "text/xml; charset=iso-8859-1", []string{"warning: the feed declares charset UTF-8 but is served as iso-8859-1"}},
+		{"unknown charset", strings.Replace(feed(goodItem), "UTF-8", "klingon", 1), "", []string{`error: unknown charset "klingon"`}},
+		{"not a feed", "<html><body>Not found</body></html>", "", []string{"error: could not parse the feed"}},
+		{"broken item", feed(strings.Replace(goodItem, `length="1024"`, `length="1kB"`, 1)), "", []string{`error: line 5: skipped item "Good"`}},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			r := Feed([]byte(e.feed), Options{ContentType: e.contentType})
+
+			got := problems(r)
+			if len(r.Problems) != len(e.want) {
+				t.Fatalf("Expected %d problems, but got:\n%s", len(e.want), got)
+			}
+			for i, want := range e.want {
+				if !strings.HasPrefix(r.Problems[i].String(), want) {
+					t.Errorf("Expected %#v, but got: %#v", want, r.Problems[i].String())
+				}
+			}
+		})
+	}
+}
+
+func TestFeedMedia(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing.mp3":
+			w.WriteHeader(http.StatusNotFound)
+		case "/no-head.mp3":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	items := ""
+	for _, name := range []string{"good", "missing", "no-head"} {
+		items += strings.NewReplacer("http://example.com/good.mp3", ts.URL+"/"+name+".mp3", "good", name).Replace(goodItem)
+	}
+
+	r := Feed([]byte(feed(items)), Options{Client: ts.Client()})
+	if len(r.Problems) != 1 || !strings.HasSuffix(r.Problems[0].String(), "/missing.mp3: responds with 404 Not Found") {
+		t.Errorf("Expected the missing media file to be reported, but got:\n%s", problems(r))
+	}
+	if r.Count(Error) != 1 || r.Count(Warning) != 0 {
+		t.Errorf("Expected 1 error, but got %d errors and %d warnings", r.Count(Error), r.Count(Warning))
+	}
+}